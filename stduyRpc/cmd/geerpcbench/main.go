@@ -0,0 +1,215 @@
+// geerpcbench measures the throughput and latency of a geerpc service.
+//
+// It dials -addr with the syntax of geerpc.XDial (tcp@, http@, unix@),
+// calls -method with the JSON -args from -c concurrent workers, at most
+// -qps calls per second if set, for -d or -n calls, then reports the QPS
+// and the latency percentiles.
+//
+// The args are sent as raw bytes: with -codec json the server decodes the
+// JSON into the args of the method, with -codec gob only methods taking
+// []byte, like the builtin _geerpc.Echo, can be called. Without -addr, a
+// local server is started to measure the overhead of the framework with
+// _geerpc.Echo.
+//
+// Usage:
+//
+//	geerpcbench [-addr tcp@host:port] [-codec json] [-method Foo.Sum] [-args '{"Num1":1,"Num2":2}']
+//		[-c 10] [-conns 1] [-qps 0] [-d 10s] [-n 0] [-timeout 1s]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"studyRpc/codec"
+	"studyRpc/geerpc"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	addr        = flag.String("addr", "", "server address, tcp@host:port, http@host:port or unix@path; a local server if empty")
+	codecName   = flag.String("codec", "json", "codec on the wire, json or gob")
+	method      = flag.String("method", geerpc.EchoMethod, "Service.Method to call")
+	args        = flag.String("args", `"hello"`, "args of the calls, in JSON")
+	concurrency = flag.Int("c", 10, "number of concurrent workers")
+	conns       = flag.Int("conns", 1, "number of connections shared by the workers")
+	qps         = flag.Float64("qps", 0, "maximum calls per second, 0 means no limit")
+	duration    = flag.Duration("d", 10*time.Second, "duration of the test, if -n is 0")
+	total       = flag.Int64("n", 0, "number of calls, 0 means run for -d")
+	timeout     = flag.Duration("timeout", time.Second, "timeout of a call")
+)
+
+var codecs = map[string]codec.Type{"json": codec.JsonType, "gob": codec.GobType}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("geerpcbench: ")
+	flag.Parse()
+
+	codecType, ok := codecs[*codecName]
+	if !ok {
+		log.Fatalf("unknown codec %q", *codecName)
+	}
+	if !json.Valid([]byte(*args)) {
+		log.Fatalf("-args isn't valid JSON: %s", *args)
+	}
+	if *concurrency < 1 || *conns < 1 {
+		log.Fatal("-c and -conns must be at least 1")
+	}
+	if *qps < 0 || math.IsInf(*qps, 0) || math.IsNaN(*qps) {
+		log.Fatalf("-qps must be a positive number or 0, got %v", *qps)
+	}
+	if *addr == "" {
+		*addr = startLocalServer()
+	}
+
+	clients := make([]*geerpc.Client, *conns)
+	for i := range clients {
+		client, err := geerpc.XDial(*addr, &geerpc.Option{CodecType: codecType})
+		if err != nil {
+			log.Fatal("dial failed: ", err)
+		}
+		defer func() { _ = client.Close() }()
+		clients[i] = client
+	}
+
+	b := &bench{args: json.RawMessage(*args)}
+	if *qps > 0 {
+		b.limiter = newLimiter(*qps)
+	}
+	if *total == 0 {
+		b.deadline = time.Now().Add(*duration)
+	}
+	start := time.Now()
+	var wg sync.WaitGroup
+	latencies := make([][]time.Duration, *concurrency)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			latencies[i] = b.work(clients[i%len(clients)])
+		}(i)
+	}
+	wg.Wait()
+	report(merge(latencies), time.Since(start), atomic.LoadInt64(&b.errors), b.firstErr)
+}
+
+// startLocalServer serves the builtin methods on a loopback port.
+func startLocalServer() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal("listen failed: ", err)
+	}
+	go geerpc.NewServer().Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+type bench struct {
+	args     json.RawMessage
+	limiter  *limiter  // enforces -qps, nil if no limit
+	deadline time.Time // zero when running -n calls
+	calls    int64
+	errors   int64
+	errOnce  sync.Once
+	firstErr error
+}
+
+// limiter is a token bucket refilled at rate tokens per second, holding
+// at most one second worth of tokens.
+type limiter struct {
+	rate   float64
+	mu     sync.Mutex // protect following
+	tokens float64    // negative when callers are waiting for future tokens
+	last   time.Time  // last refill
+}
+
+func newLimiter(rate float64) *limiter {
+	return &limiter{rate: rate, tokens: 1, last: time.Now()}
+}
+
+// wait takes a token, sleeping until it is available. It returns false
+// without a token if that would be after deadline, unless it is zero.
+func (l *limiter) wait(deadline time.Time) bool {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(math.Max(l.rate, 1), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	var delay time.Duration
+	if l.tokens < 1 {
+		delay = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	}
+	if !deadline.IsZero() && now.Add(delay).After(deadline) {
+		l.mu.Unlock()
+		return false
+	}
+	l.tokens-- // reserved, the next callers wait for the following tokens
+	l.mu.Unlock()
+	time.Sleep(delay)
+	return true
+}
+
+// next reports whether another call must be made.
+func (b *bench) next() bool {
+	if b.deadline.IsZero() {
+		return atomic.AddInt64(&b.calls, 1) <= *total
+	}
+	return time.Now().Before(b.deadline)
+}
+
+// work makes calls until the end of the test and returns their latencies.
+func (b *bench) work(client *geerpc.Client) []time.Duration {
+	var latencies []time.Duration
+	for b.next() {
+		if b.limiter != nil && !b.limiter.wait(b.deadline) {
+			break // the test ends before the next token
+		}
+		var reply json.RawMessage
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		start := time.Now()
+		err := client.Call(ctx, *method, b.args, &reply)
+		latencies = append(latencies, time.Since(start))
+		cancel()
+		if err != nil {
+			atomic.AddInt64(&b.errors, 1)
+			b.errOnce.Do(func() { b.firstErr = err })
+		}
+	}
+	return latencies
+}
+
+func merge(latencies [][]time.Duration) []time.Duration {
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	return all
+}
+
+func report(latencies []time.Duration, elapsed time.Duration, errors int64, firstErr error) {
+	n := len(latencies)
+	fmt.Printf("calls:    %d (%d errors)\n", n, errors)
+	if firstErr != nil {
+		fmt.Printf("error:    %v\n", firstErr)
+	}
+	fmt.Printf("duration: %v\n", elapsed.Round(time.Millisecond))
+	if n == 0 {
+		return
+	}
+	fmt.Printf("qps:      %.1f\n", float64(n)/elapsed.Seconds())
+	fmt.Printf("latency:  min %v  p50 %v  p90 %v  p99 %v  max %v\n",
+		latencies[0], percentile(latencies, 0.5), percentile(latencies, 0.9),
+		percentile(latencies, 0.99), latencies[n-1])
+}
+
+// percentile returns the q-th quantile of the sorted latencies.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
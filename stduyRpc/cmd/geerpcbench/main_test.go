@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"studyRpc/codec"
+	"studyRpc/geerpc"
+	"testing"
+	"time"
+)
+
+func TestBench_work(t *testing.T) {
+	client, err := geerpc.XDial(startLocalServer(), &geerpc.Option{CodecType: codec.JsonType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	tests := []struct {
+		name     string
+		rate     float64
+		min, max int
+	}{
+		{"qps", 20, 4, 6},     // a first token, then 20/s for 200ms
+		{"slow qps", 1, 1, 1}, // the second token comes after the deadline
+	}
+	for _, tt := range tests {
+		b := &bench{args: json.RawMessage(`"hello"`), limiter: newLimiter(tt.rate), deadline: time.Now().Add(200 * time.Millisecond)}
+		start := time.Now()
+		latencies := b.work(client)
+		if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+			t.Errorf("%s: expect the worker to stop at the deadline, took %v", tt.name, elapsed)
+		}
+		if n := len(latencies); n < tt.min || n > tt.max || b.errors != 0 {
+			t.Errorf("%s: expect %d to %d calls, got %d (%d errors, %v)", tt.name, tt.min, tt.max, n, b.errors, b.firstErr)
+		}
+	}
+}
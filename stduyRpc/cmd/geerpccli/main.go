@@ -0,0 +1,118 @@
+// geerpccli calls the methods of a geerpc server from the command line,
+// using the JSON codec on the wire.
+//
+// Usage:
+//
+//	geerpccli -addr tcp@host:port list
+//	geerpccli -addr tcp@host:port call Foo.Sum '{"Num1":1,"Num2":2}'
+//
+// -addr has the syntax of geerpc.XDial: tcp@, http@ or unix@. list prints
+// the registered methods with a JSON template of their args, call prints
+// the reply as JSON.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"studyRpc/codec"
+	"studyRpc/geerpc"
+	"text/tabwriter"
+	"time"
+)
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), `usage:
+	geerpccli -addr protocol@addr list
+	geerpccli -addr protocol@addr call Service.Method [JSON args]
+`)
+		fs.PrintDefaults()
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command with the given arguments and returns its exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	logger := log.New(stderr, "geerpccli: ", 0)
+	fs := flag.NewFlagSet("geerpccli", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = usage(fs)
+	addr := fs.String("addr", "", "server address, tcp@host:port, http@host:port or unix@path")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of the connection and of the call")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	var cmd func(ctx context.Context, client *geerpc.Client) error
+	switch name := fs.Arg(0); {
+	case name == "list" && fs.NArg() == 1:
+		cmd = func(ctx context.Context, client *geerpc.Client) error { return list(ctx, client, stdout) }
+	case name == "call" && (fs.NArg() == 2 || fs.NArg() == 3):
+		args := "null" // the zero args
+		if fs.NArg() == 3 {
+			args = fs.Arg(2)
+		}
+		cmd = func(ctx context.Context, client *geerpc.Client) error {
+			return call(ctx, client, stdout, fs.Arg(1), args)
+		}
+	}
+	if *addr == "" || cmd == nil {
+		fs.Usage()
+		return 2
+	}
+
+	client, err := geerpc.XDial(*addr, &geerpc.Option{CodecType: codec.JsonType, ConnectTimeout: *timeout})
+	if err != nil {
+		logger.Print("dial failed: ", err)
+		return 1
+	}
+	defer func() { _ = client.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := cmd(ctx, client); err != nil {
+		logger.Print(err)
+		return 1
+	}
+	return 0
+}
+
+func list(ctx context.Context, client *geerpc.Client, out io.Writer) error {
+	var methods []geerpc.MethodInfo
+	if err := client.Call(ctx, geerpc.ServicesMethod, "", &methods); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tARGS\tREPLY\tCALLS")
+	for _, m := range methods {
+		name := m.Name
+		if m.OneWay {
+			name += " (one-way)"
+		}
+		fmt.Fprintf(w, "%s\t%s %s\t%s\t%d\n", name, m.ArgType, m.Args, m.ReplyType, m.Calls)
+	}
+	return w.Flush()
+}
+
+func call(ctx context.Context, client *geerpc.Client, w io.Writer, serviceMethod, args string) error {
+	if !json.Valid([]byte(args)) {
+		return fmt.Errorf("args aren't valid JSON: %s", args)
+	}
+	var reply json.RawMessage
+	if err := client.Call(ctx, serviceMethod, json.RawMessage(args), &reply); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, reply, "", "  "); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, out.String())
+	return nil
+}
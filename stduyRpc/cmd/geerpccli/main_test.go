@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"studyRpc/geerpc"
+	"testing"
+)
+
+type Args struct{ Num1, Num2 int }
+
+// startServer serves Foo.Sum and the one-way Log.Write until the test ends.
+func startServer(t *testing.T) string {
+	server := geerpc.NewServer()
+	_ = server.RegisterFunc("Foo.Sum", func(args Args, reply *int) error {
+		*reply = args.Num1 + args.Num2
+		return nil
+	})
+	_ = server.RegisterFunc("Log.Write", func(msg string, reply *struct{}) error { return nil })
+	_ = server.SetOneWay("Log.Write")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	addr := startServer(t)
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string // a substring of stderr
+	}{
+		{"list", []string{"-addr", addr, "list"}, 0,
+			"METHOD               ARGS                           REPLY       CALLS\n" +
+				"Foo.Sum              main.Args {\"Num1\":0,\"Num2\":0}  *int        0\n" +
+				"Log.Write (one-way)  string \"\"                      *struct {}  0\n", ""},
+		{"call", []string{"-addr", addr, "call", "Foo.Sum", `{"Num1":1,"Num2":2}`}, 0, "3\n", ""},
+		{"zero args", []string{"-addr", addr, "call", "Foo.Sum"}, 0, "0\n", ""},
+		{"invalid args", []string{"-addr", addr, "call", "Foo.Sum", `{"Num1":`}, 1, "", "args aren't valid JSON"},
+		{"unknown method", []string{"-addr", addr, "call", "Foo.Nope"}, 1, "", "can't find method Nope"},
+		{"no addr", []string{"list"}, 2, "", "usage:"},
+		{"no command", []string{"-addr", addr}, 2, "", "usage:"},
+		{"unknown command", []string{"-addr", addr, "ping"}, 2, "", "usage:"},
+		{"call without method", []string{"-addr", addr, "call"}, 2, "", "usage:"},
+		{"unknown flag", []string{"-nope", "list"}, 2, "", "flag provided but not defined"},
+		{"dial failure", []string{"-addr", "tcp@127.0.0.1:1", "list"}, 1, "", "dial failed"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(tt.args, &stdout, &stderr)
+		if code != tt.code || stdout.String() != tt.stdout || !strings.Contains(stderr.String(), tt.stderr) {
+			t.Errorf("%s: got code %d, stdout %q, stderr %q", tt.name, code, stdout.String(), stderr.String())
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// geerpcgen generates typed client wrappers for geerpc services.
+//
+// It parses the Go package in -dir, finds exported types with methods that
+// geerpc.Server.Register would publish, i.e.
+//
+//	func (t *T) Method([ctx context.Context,] args T1, reply *T2) error
+//
+// and writes a client per type into the same package:
+//
+//	c := NewFooClient(client) // *geerpc.Client or *xclient.XClient
+//	sum, err := c.Sum(ctx, Args{Num1: 1, Num2: 2})
+//
+// Usage:
+//
+//	geerpcgen [-dir .] [-type Foo,Bar] [-output geerpc_client.go]
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	dir      = flag.String("dir", ".", "directory of the package to parse")
+	typeList = flag.String("type", "", "comma-separated list of service types, default all suitable types")
+	output   = flag.String("output", "geerpc_client.go", "output file name, relative to -dir")
+)
+
+// method is a service method satisfying the rules of geerpc's registerMethods.
+type method struct {
+	Name      string
+	ArgType   string // type expression of args
+	ReplyType string // type expression reply points to
+}
+
+type generator struct {
+	pkg      string
+	services map[string][]method          // service type name -> methods
+	imports  map[string]map[string]string // service type name -> import path -> explicit name, "" if none
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("geerpcgen: ")
+	flag.Parse()
+
+	g := &generator{services: make(map[string][]method), imports: make(map[string]map[string]string)}
+	if err := g.parseDir(*dir); err != nil {
+		log.Fatal(err)
+	}
+	if err := g.filter(*typeList); err != nil {
+		log.Fatal(err)
+	}
+	if len(g.services) == 0 {
+		log.Fatalf("no service found in %s", *dir)
+	}
+	src, err := g.generate()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(*dir, *output), src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (g *generator) parseDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") || filepath.Base(name) == *output {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			return err
+		}
+		if g.pkg == "" {
+			g.pkg = f.Name.Name
+		} else if g.pkg != f.Name.Name {
+			return fmt.Errorf("multiple packages in %s: %s and %s", dir, g.pkg, f.Name.Name)
+		}
+		g.parseFile(f)
+	}
+	for name := range g.services {
+		sort.Slice(g.services[name], func(i, j int) bool { return g.services[name][i].Name < g.services[name][j].Name })
+	}
+	return nil
+}
+
+// filter keeps the services in the comma-separated typeList, all if it is empty.
+func (g *generator) filter(typeList string) error {
+	if typeList == "" {
+		return nil
+	}
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(typeList, ",") {
+		wanted[strings.TrimSpace(name)] = true
+	}
+	for name := range g.services {
+		if !wanted[name] {
+			delete(g.services, name)
+		}
+	}
+	for name := range wanted {
+		if _, ok := g.services[name]; !ok {
+			return fmt.Errorf("type %s has no methods of suitable type", name)
+		}
+	}
+	return nil
+}
+
+func (g *generator) parseFile(f *ast.File) {
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || !fn.Name.IsExported() {
+			continue
+		}
+		service := receiverName(fn.Recv.List[0].Type)
+		if !ast.IsExported(service) {
+			continue
+		}
+		argType, replyType, ok := methodTypes(f, fn.Type)
+		if !ok {
+			continue
+		}
+		g.addImports(f, service, argType, replyType)
+		g.services[service] = append(g.services[service], method{
+			Name:      fn.Name.Name,
+			ArgType:   types.ExprString(argType),
+			ReplyType: types.ExprString(replyType),
+		})
+	}
+}
+
+// receiverName returns the type name of a receiver T or *T.
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// methodTypes checks that ft is func([ctx context.Context,] args T1, reply *T2) error
+// with exported or builtin types and returns T1 and T2.
+func methodTypes(f *ast.File, ft *ast.FuncType) (argType, replyType ast.Expr, ok bool) {
+	var params []ast.Expr
+	for _, field := range ft.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, field.Type)
+		}
+	}
+	// geerpc passes the request context to an optional leading context.Context,
+	// the package is resolved through the imports since it may be renamed
+	if len(params) == 3 {
+		if sel, ok := params[0].(*ast.SelectorExpr); ok && sel.Sel.Name == "Context" {
+			if pkg, ok := sel.X.(*ast.Ident); ok && importPath(f, pkg.Name) == "context" {
+				params = params[1:]
+			}
+		}
+	}
+	if len(params) != 2 || ft.Results == nil || len(ft.Results.List) != 1 || len(ft.Results.List[0].Names) > 1 {
+		return nil, nil, false
+	}
+	if ident, ok := ft.Results.List[0].Type.(*ast.Ident); !ok || ident.Name != "error" {
+		return nil, nil, false
+	}
+	star, ok := params[1].(*ast.StarExpr)
+	if !ok || !isExportedOrBuiltinType(params[0]) || !isExportedOrBuiltinType(params[1]) {
+		return nil, nil, false
+	}
+	return params[0], star.X, true
+}
+
+// isExportedOrBuiltinType mirrors the check geerpc does with reflection.
+func isExportedOrBuiltinType(expr ast.Expr) bool {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return isExportedOrBuiltinType(t.X)
+	case *ast.Ident:
+		_, builtin := types.Universe.Lookup(t.Name).(*types.TypeName)
+		return t.IsExported() || builtin
+	case *ast.SelectorExpr:
+		return t.Sel.IsExported()
+	default:
+		// unnamed types such as []T or map[K]V
+		return true
+	}
+}
+
+// addImports records the imports of f used by the given type expressions
+// of service.
+func (g *generator) addImports(f *ast.File, service string, exprs ...ast.Expr) {
+	if g.imports[service] == nil {
+		g.imports[service] = make(map[string]string)
+	}
+	for _, expr := range exprs {
+		ast.Inspect(expr, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			pkg, ok := sel.X.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			if p := importPath(f, pkg.Name); p != "" {
+				if path.Base(p) == pkg.Name {
+					g.imports[service][p] = ""
+				} else {
+					g.imports[service][p] = pkg.Name
+				}
+			}
+			return false
+		})
+	}
+}
+
+// importPath returns the path of the package f imports under name, "" if none.
+func importPath(f *ast.File, name string) string {
+	for _, spec := range f.Imports {
+		p, _ := strconv.Unquote(spec.Path.Value)
+		if spec.Name != nil && spec.Name.Name == name || spec.Name == nil && path.Base(p) == name {
+			return p
+		}
+	}
+	return ""
+}
+
+func (g *generator) generate() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by geerpcgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", g.pkg)
+	fmt.Fprintf(&buf, "import (\n\t\"context\"\n")
+	imports := make(map[string]string)
+	for name := range g.services {
+		for p, pkg := range g.imports[name] {
+			imports[p] = pkg
+		}
+	}
+	var paths []string
+	for p := range imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fmt.Fprintf(&buf, "\t%s %q\n", imports[p], p)
+	}
+	fmt.Fprintf(&buf, ")\n\n")
+	fmt.Fprintf(&buf, `// geerpcCaller is implemented by *geerpc.Client and *xclient.XClient.
+type geerpcCaller interface {
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}) error
+}
+`)
+
+	var names []string
+	for name := range g.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, `
+// %[1]sClient is a typed client of the %[1]s service.
+type %[1]sClient struct {
+	c geerpcCaller
+	// Service is the name the service is registered under, %[1]q by default.
+	Service string
+}
+
+// New%[1]sClient returns a client of the %[1]s service calling through c.
+func New%[1]sClient(c geerpcCaller) *%[1]sClient {
+	return &%[1]sClient{c: c, Service: %[1]q}
+}
+`, name)
+		for _, m := range g.services[name] {
+			fmt.Fprintf(&buf, `
+// %[2]s calls %[1]s.%[2]s.
+func (c *%[1]sClient) %[2]s(ctx context.Context, args %[3]s) (%[4]s, error) {
+	var reply %[4]s
+	err := c.c.Call(ctx, c.Service+".%[2]s", args, &reply)
+	return reply, err
+}
+`, name, m.Name, m.ArgType, m.ReplyType)
+		}
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		golden   string
+		typeList string
+	}{
+		{"all.golden", ""},
+		{"clock.golden", "Clock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			g := &generator{services: make(map[string][]method), imports: make(map[string]map[string]string)}
+			if err := g.parseDir(filepath.Join("testdata", "svc")); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.filter(tt.typeList); err != nil {
+				t.Fatal(err)
+			}
+			src, err := g.generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(golden, src, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(src, want) {
+				t.Errorf("generated code differs from %s:\n%s", golden, src)
+			}
+			typeCheck(t, src)
+		})
+	}
+}
+
+func TestGenerate_unknownType(t *testing.T) {
+	g := &generator{services: make(map[string][]method), imports: make(map[string]map[string]string)}
+	if err := g.parseDir(filepath.Join("testdata", "svc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.filter("Arith,private"); err == nil {
+		t.Fatal("expect an error for a type without suitable methods")
+	}
+}
+
+// typeCheck checks src along with the package of testdata/svc it belongs to.
+func typeCheck(t *testing.T, src []byte) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, filepath.Join("testdata", "svc"), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []*ast.File
+	for _, f := range pkgs["svc"].Files {
+		files = append(files, f)
+	}
+	f, err := parser.ParseFile(fset, "geerpc.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, f)
+	conf := types.Config{Importer: stubImporter{importer.ForCompiler(fset, "source", nil)}}
+	if _, err := conf.Check("svc", fset, files, nil); err != nil {
+		t.Errorf("generated code doesn't compile: %v", err)
+	}
+}
+
+// stubs are the types of the made up packages imported by testdata/svc.
+var stubs = map[string][]string{
+	"example.com/proto":        {"Request", "Response"},
+	"example.com/fake/context": {"Context"},
+}
+
+// stubImporter imports the made up packages as empty structs, and the
+// standard library with std.
+type stubImporter struct {
+	std types.Importer
+}
+
+func (im stubImporter) Import(importPath string) (*types.Package, error) {
+	names, ok := stubs[importPath]
+	if !ok {
+		return im.std.Import(importPath)
+	}
+	pkg := types.NewPackage(importPath, path.Base(importPath))
+	for _, name := range names {
+		obj := types.NewTypeName(token.NoPos, pkg, name, nil)
+		types.NewNamed(obj, types.NewStruct(nil, nil), nil)
+		pkg.Scope().Insert(obj)
+	}
+	pkg.MarkComplete()
+	return pkg, nil
+}
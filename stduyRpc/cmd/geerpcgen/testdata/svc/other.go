@@ -0,0 +1,15 @@
+package svc
+
+import (
+	stdctx "context"
+
+	context "example.com/fake/context"
+)
+
+type Clock struct{}
+
+// Now takes the request context under another name.
+func (c Clock) Now(ctx stdctx.Context, args int, reply *int64) error { return nil }
+
+// Fake isn't suitable, its context.Context isn't the standard one.
+func (c Clock) Fake(ctx context.Context, args int, reply *int64) error { return nil }
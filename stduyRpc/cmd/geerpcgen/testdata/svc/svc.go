@@ -0,0 +1,44 @@
+package svc
+
+import (
+	"context"
+	"time"
+
+	pb "example.com/proto"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Reply struct{ Sum int }
+
+type Arith int
+
+// Sum takes its args by value.
+func (a *Arith) Sum(args Args, reply *int) error { return nil }
+
+// Mul takes a pointer to its args.
+func (a *Arith) Mul(args *Args, reply *Reply) error { return nil }
+
+// Wait takes the request context.
+func (a *Arith) Wait(ctx context.Context, d time.Duration, reply *time.Time) error { return nil }
+
+// Proto uses the types of a renamed import.
+func (a *Arith) Proto(args *pb.Request, reply *pb.Response) error { return nil }
+
+// unsuitable methods
+
+func (a *Arith) unexported(args Args, reply *int) error { return nil }
+
+func (a *Arith) NoPointer(args Args, reply int) error { return nil }
+
+func (a *Arith) NoError(args Args, reply *int) int { return 0 }
+
+func (a *Arith) Private(args args, reply *int) error { return nil }
+
+func (a *Arith) TooMany(x, y Args, reply *int) error { return nil }
+
+type args struct{}
+
+type private int
+
+func (p private) Sum(args Args, reply *int) error { return nil }
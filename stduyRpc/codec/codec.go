@@ -6,7 +6,20 @@ type Header struct {
 	ServiceMethod string // format "Service.Method"       		服务名和方法名
 	Seq           uint64 // sequence number chosen by client 	请求的序号，也可以认为是某个请求的 ID，用来区分不同的请求
 	Error         string //										Error 是错误信息，客户端置为空，服务端如果如果发生错误，将错误信息置于 Error 中
+	OneWay        bool   // 单向请求，服务端不回复
+	Batch         int    // 批量请求的请求数，同一批的每个请求都会携带，0 表示非批量请求
+	TraceID       string // 调用链 ID，同一调用链的所有请求相同
+	SpanID        string // 发起请求的客户端 span，服务端 span 的 parent
+	NoCache       bool   // 不使用服务端缓存的结果，见 geerpc.WithNoCache
+	ErrorKind     int    // Error 的类别，见 ErrorOther 等，供 JSON-RPC 这类需要错误码的编码使用
 }
+
+// Kinds of the errors a server reports in Header.Error.
+const (
+	ErrorOther          = iota // errors of the service methods, timeouts...
+	ErrorMethodNotFound        // unknown or ill-formed service method
+	ErrorInvalidArgs           // the body can't be decoded into the args
+)
 //消息体进行编解码的接口 Codec
 type Codec interface {
 	io.Closer
@@ -15,13 +28,33 @@ type Codec interface {
 	Write(*Header, interface{}) error
 }
 
+// BatchWriter is implemented by codecs that can write several messages
+// with a single flush of the underlying connection.
+type BatchWriter interface {
+	WriteBatch(hs []*Header, bodies []interface{}) error
+}
+
+// WriteBatch writes hs[i], bodies[i] pairs to c, flushing once if c
+// implements BatchWriter and falling back to one Write per message otherwise.
+func WriteBatch(c Codec, hs []*Header, bodies []interface{}) error {
+	if bw, ok := c.(BatchWriter); ok {
+		return bw.WriteBatch(hs, bodies)
+	}
+	for i, h := range hs {
+		if err := c.Write(h, bodies[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type NewCodecFunc func(io.ReadWriteCloser) Codec
 
 type Type string
 
 const (
 	GobType  Type = "application/gob"
-	JsonType Type = "application/json" // not implemented
+	JsonType Type = "application/json"
 )
 
 var NewCodecFuncMap map[Type]NewCodecFunc
@@ -29,4 +62,5 @@ var NewCodecFuncMap map[Type]NewCodecFunc
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
 }
\ No newline at end of file
@@ -42,6 +42,28 @@ func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
 			_ = c.Close()
 		}
 	}()
+	return c.encode(h, body)
+}
+
+var _ BatchWriter = (*GobCodec)(nil)
+
+// WriteBatch encodes all messages into the buffer and flushes it once.
+func (c *GobCodec) WriteBatch(hs []*Header, bodies []interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	for i, h := range hs {
+		if err := c.encode(h, bodies[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *GobCodec) encode(h *Header, body interface{}) error {
 	//将Header和body编码写到buf
 	if err := c.enc.Encode(h); err != nil {
 		log.Println("rpc codec: gob error encoding header:", err)
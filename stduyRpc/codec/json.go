@@ -0,0 +1,86 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+// JsonCodec encodes headers and bodies as a stream of JSON values, for
+// peers that don't speak gob.
+type JsonCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+var _ Codec = (*JsonCodec)(nil)
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &JsonCodec{
+		conn: conn,
+		buf:  buf,
+		dec:  json.NewDecoder(conn),
+		enc:  json.NewEncoder(buf),
+	}
+}
+
+func (c *JsonCodec) ReadHeader(h *Header) error {
+	return c.dec.Decode(h)
+}
+
+// ReadBody decodes the body into body, a nil body is discarded.
+func (c *JsonCodec) ReadBody(body interface{}) error {
+	if body == nil {
+		var discard json.RawMessage
+		return c.dec.Decode(&discard)
+	}
+	return c.dec.Decode(body)
+}
+
+func (c *JsonCodec) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	return c.encode(h, body)
+}
+
+var _ BatchWriter = (*JsonCodec)(nil)
+
+// WriteBatch encodes all messages into the buffer and flushes it once.
+func (c *JsonCodec) WriteBatch(hs []*Header, bodies []interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	for i, h := range hs {
+		if err := c.encode(h, bodies[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *JsonCodec) encode(h *Header, body interface{}) error {
+	if err := c.enc.Encode(h); err != nil {
+		log.Println("rpc codec: json error encoding header:", err)
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		log.Println("rpc codec: json error encoding body:", err)
+		return err
+	}
+	return nil
+}
+
+func (c *JsonCodec) Close() error {
+	return c.conn.Close()
+}
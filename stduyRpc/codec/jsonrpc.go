@@ -0,0 +1,291 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCServerError    = -32000
+)
+
+// JSONRPCCodec is a server side Codec speaking JSON-RPC 2.0. Requests are
+// JSON values, typically one per line, each an object or a batch array.
+// Requests without id are notifications and read as one-way, the requests
+// of a batch carry Header.Batch and their responses are written together
+// as a single array.
+type JSONRPCCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *json.Decoder
+
+	// only used by the reading goroutine
+	queue  []*jsonrpcRequest // unread requests of the current batch
+	batch  *jsonrpcBatch     // the current batch, nil for a single request
+	batchN int               // number of requests in the current batch
+	params json.RawMessage   // params of the last request read
+	cur    uint64            // seq of the last request read
+
+	mu      sync.Mutex // protect following and writes to buf
+	seq     uint64
+	pending map[uint64]*jsonrpcPending
+}
+
+var _ Codec = (*JSONRPCCodec)(nil)
+
+type jsonrpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"` // nil if absent, "null" if null
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// jsonrpcPending is a request waiting for its response.
+type jsonrpcPending struct {
+	id    json.RawMessage
+	batch *jsonrpcBatch
+}
+
+type jsonrpcBatch struct {
+	remaining int // responses still expected
+	responses []json.RawMessage
+}
+
+var null = json.RawMessage("null")
+
+func NewJSONRPCCodec(conn io.ReadWriteCloser) Codec {
+	return &JSONRPCCodec{
+		conn:    conn,
+		buf:     bufio.NewWriter(conn),
+		dec:     json.NewDecoder(conn),
+		pending: make(map[uint64]*jsonrpcPending),
+	}
+}
+
+func (c *JSONRPCCodec) ReadHeader(h *Header) error {
+	for len(c.queue) == 0 {
+		if err := c.readValue(); err != nil {
+			return err
+		}
+	}
+	req := c.queue[0]
+	c.queue = c.queue[1:]
+
+	c.mu.Lock()
+	c.seq++
+	c.cur = c.seq
+	if req.ID != nil {
+		c.pending[c.cur] = &jsonrpcPending{id: req.ID, batch: c.batch}
+	}
+	c.mu.Unlock()
+
+	*h = Header{ServiceMethod: req.Method, Seq: c.cur, OneWay: req.ID == nil, Batch: c.batchN}
+	c.params = req.Params
+	return nil
+}
+
+// readValue reads the next JSON value and queues its valid requests,
+// invalid ones are answered right away.
+func (c *JSONRPCCodec) readValue() error {
+	var raw json.RawMessage
+	if err := c.dec.Decode(&raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) || err == io.ErrUnexpectedEOF {
+			// the stream can't be resynchronized, answer and give up
+			c.writeError(null, JSONRPCParseError, "parse error: "+err.Error())
+		}
+		return err
+	}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] != '[' {
+		c.batch, c.batchN = nil, 0
+		if req, err := parseJSONRPCRequest(raw); err != nil {
+			c.writeError(idOf(req), JSONRPCInvalidRequest, err.Error())
+		} else {
+			c.queue = append(c.queue, req)
+		}
+		return nil
+	}
+
+	var elems []json.RawMessage
+	if err := json.Unmarshal(raw, &elems); err != nil || len(elems) == 0 {
+		c.writeError(null, JSONRPCInvalidRequest, "invalid request: empty or malformed batch")
+		return nil
+	}
+	batch := &jsonrpcBatch{}
+	for _, elem := range elems {
+		req, err := parseJSONRPCRequest(elem)
+		if err != nil {
+			batch.responses = append(batch.responses, c.marshalError(idOf(req), JSONRPCInvalidRequest, err.Error()))
+			continue
+		}
+		if req.ID != nil {
+			batch.remaining++
+		}
+		c.queue = append(c.queue, req)
+	}
+	c.batch, c.batchN = batch, len(c.queue)
+	if batch.remaining == 0 && len(batch.responses) > 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.flushBatch(batch)
+	}
+	return nil
+}
+
+func parseJSONRPCRequest(raw json.RawMessage) (*jsonrpcRequest, error) {
+	req := new(jsonrpcRequest)
+	if err := json.Unmarshal(raw, req); err != nil {
+		return nil, errors.New("invalid request: " + err.Error())
+	}
+	if req.Version != "2.0" {
+		return req, errors.New(`invalid request: jsonrpc must be "2.0"`)
+	}
+	if req.Method == "" {
+		return req, errors.New("invalid request: missing method")
+	}
+	return req, nil
+}
+
+func idOf(req *jsonrpcRequest) json.RawMessage {
+	if req == nil || req.ID == nil {
+		return null
+	}
+	return req.ID
+}
+
+// ReadBody decodes the params of the last request into body. Since geerpc
+// methods take one argument, a single element array is unwrapped unless
+// the argument is itself a slice or an array.
+func (c *JSONRPCCodec) ReadBody(body interface{}) error {
+	params := bytes.TrimSpace(c.params)
+	c.params = nil
+	if body == nil || len(params) == 0 || bytes.Equal(params, null) {
+		return nil
+	}
+	if params[0] == '[' && !isList(body) {
+		var elems []json.RawMessage
+		if err := json.Unmarshal(params, &elems); err == nil && len(elems) == 1 {
+			params = elems[0]
+		}
+	}
+	if err := json.Unmarshal(params, body); err != nil {
+		return errors.New("invalid params: " + err.Error())
+	}
+	return nil
+}
+
+// isList reports whether body points to a slice or an array.
+func isList(body interface{}) bool {
+	t := reflect.TypeOf(body)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Slice || t.Kind() == reflect.Array
+}
+
+// Write answers the request h.Seq, notifications get no response.
+func (c *JSONRPCCodec) Write(h *Header, body interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.pending[h.Seq]
+	if p == nil {
+		return nil
+	}
+	delete(c.pending, h.Seq)
+
+	var resp json.RawMessage
+	if h.Error != "" {
+		code := JSONRPCServerError
+		switch h.ErrorKind {
+		case ErrorInvalidArgs:
+			code = JSONRPCInvalidParams
+		case ErrorMethodNotFound:
+			code = JSONRPCMethodNotFound
+		}
+		resp = c.marshalError(p.id, code, h.Error)
+	} else {
+		var err error
+		resp, err = json.Marshal(jsonrpcResponse{Version: "2.0", Result: resultOf(body), ID: p.id})
+		if err != nil {
+			log.Println("rpc codec: jsonrpc error encoding result:", err)
+			resp = c.marshalError(p.id, JSONRPCServerError, "encoding result: "+err.Error())
+		}
+	}
+	if p.batch == nil {
+		return c.write(resp)
+	}
+	p.batch.responses = append(p.batch.responses, resp)
+	p.batch.remaining--
+	if p.batch.remaining == 0 {
+		return c.flushBatch(p.batch)
+	}
+	return nil
+}
+
+// resultOf makes sure a successful response always has a result member.
+func resultOf(body interface{}) interface{} {
+	b, err := json.Marshal(body)
+	if err != nil || bytes.Equal(b, null) {
+		return null
+	}
+	return json.RawMessage(b)
+}
+
+func (c *JSONRPCCodec) marshalError(id json.RawMessage, code int, msg string) json.RawMessage {
+	resp, _ := json.Marshal(jsonrpcResponse{Version: "2.0", Error: &jsonrpcError{Code: code, Message: msg}, ID: id})
+	return resp
+}
+
+// writeError answers a request that never reaches the server.
+func (c *JSONRPCCodec) writeError(id json.RawMessage, code int, msg string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.write(c.marshalError(id, code, msg))
+}
+
+func (c *JSONRPCCodec) flushBatch(batch *jsonrpcBatch) error {
+	resp, _ := json.Marshal(batch.responses)
+	return c.write(resp)
+}
+
+// write sends one response line, c.mu must be held.
+func (c *JSONRPCCodec) write(resp json.RawMessage) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if _, err = c.buf.Write(resp); err != nil {
+		return err
+	}
+	return c.buf.WriteByte('\n')
+}
+
+func (c *JSONRPCCodec) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,108 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Every server answers the methods of the builtin service, it doesn't
+// show up in the registered services.
+const (
+	BuiltinService = "_geerpc"
+	HealthMethod   = BuiltinService + ".Health"   // args: string, how long to wait for a slot; reply: *string, HealthServing or HealthBusy
+	EchoMethod     = BuiltinService + ".Echo"     // args: json.RawMessage; reply: *json.RawMessage, the args
+	ServicesMethod = BuiltinService + ".Services" // args: string, ignored; reply: *[]MethodInfo
+)
+
+// MethodInfo describes a registered method, see ServicesMethod.
+type MethodInfo struct {
+	Name      string // Service.Method
+	ArgType   string
+	ReplyType string
+	Args      string // JSON of zero args, a template for the callers
+	OneWay    bool
+	Calls     uint64
+}
+
+// Replies of HealthMethod.
+const (
+	HealthServing = "SERVING" // the server is able to serve
+	HealthBusy    = "BUSY"    // no slot of Server.MaxConcurrent freed up in time
+)
+
+// builtin implements the methods of the builtin service.
+type builtin struct {
+	server *Server
+}
+
+// Health is answered outside of the worker pool, so that a busy server
+// still answers. It reports HealthBusy if every slot of MaxConcurrent is
+// taken and none frees up within wait, given like "100ms" (no wait if
+// empty): the methods are stuck or the server is saturated. Without
+// MaxConcurrent it can't tell, the check only shows that the server
+// accepts connections and handshakes.
+func (b *builtin) Health(wait string, status *string) error {
+	var d time.Duration
+	if wait != "" {
+		var err error
+		if d, err = time.ParseDuration(wait); err != nil {
+			return err
+		}
+	}
+	*status = HealthServing
+	if !b.server.waitSlot(d) {
+		*status = HealthBusy
+	}
+	return nil
+}
+
+// Echo replies with its args, it measures the overhead of the framework.
+// The args are raw bytes with any codec, JSON with codec.JsonType.
+func (b *builtin) Echo(args json.RawMessage, reply *json.RawMessage) error {
+	*reply = args
+	return nil
+}
+
+// Services lists the registered methods, sorted by name.
+func (b *builtin) Services(_ string, reply *[]MethodInfo) error {
+	var infos []MethodInfo
+	b.server.serviceMap.Range(func(namei, svci interface{}) bool {
+		for name, mtype := range svci.(*service).method {
+			infos = append(infos, MethodInfo{
+				Name:      namei.(string) + "." + name,
+				ArgType:   mtype.ArgType.String(),
+				ReplyType: mtype.ReplyType.String(),
+				Args:      zeroJSON(mtype.ArgType),
+				OneWay:    mtype.IsOneWay(),
+				Calls:     mtype.NumCalls(),
+			})
+		}
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	*reply = infos
+	return nil
+}
+
+// zeroJSON returns the JSON encoding of the zero value of t, of the
+// value pointed to if t is a pointer.
+func zeroJSON(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	data, err := json.Marshal(reflect.New(t).Interface())
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// builtinService returns the builtin service of server.
+func (server *Server) builtinService() *service {
+	server.builtinOnce.Do(func() {
+		server.builtin, _ = newServiceName(BuiltinService, &builtin{server: server})
+	})
+	return server.builtin
+}
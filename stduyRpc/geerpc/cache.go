@@ -0,0 +1,157 @@
+package geerpc
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/gob"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultCacheSize = 1024
+
+// resultCache is an LRU cache of the replies of a method, see Server.SetCache.
+type resultCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex // protect following
+	entries map[string]*list.Element
+	lru     list.List // of *cacheEntry, most recently used first
+
+	hits, misses uint64
+	keyErr       sync.Once // log the args that can't be encoded once
+}
+
+type cacheEntry struct {
+	key     string
+	reply   interface{}
+	expires time.Time
+}
+
+func newResultCache(ttl time.Duration, size int) *resultCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &resultCache{ttl: ttl, size: size, entries: make(map[string]*list.Element)}
+}
+
+func (c *resultCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok && time.Now().Before(e.Value.(*cacheEntry).expires) {
+		c.lru.MoveToFront(e)
+		atomic.AddUint64(&c.hits, 1)
+		return e.Value.(*cacheEntry).reply, true
+	}
+	if ok {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return nil, false
+}
+
+func (c *resultCache) put(key string, reply interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		entry.reply, entry.expires = reply, expires
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, reply: reply, expires: expires})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// SetCache caches the replies of serviceMethod for ttl, keyed by the
+// args, keeping at most size replies (1024 if size is 0), the least
+// recently used ones being evicted first. Only successful replies are
+// cached. The method must be idempotent and must not keep its reply,
+// which is sent again as is. A ttl of 0 turns caching off.
+// Callers can bypass the cache with WithNoCache, the reply they get
+// replaces the cached one.
+func (server *Server) SetCache(serviceMethod string, ttl time.Duration, size int) error {
+	_, mtype, err := server.findService(serviceMethod)
+	if err != nil {
+		return err
+	}
+	var c *resultCache
+	if ttl > 0 {
+		c = newResultCache(ttl, size)
+	}
+	mtype.cache.Store(&c)
+	return nil
+}
+
+// SetCache caches the replies of serviceMethod of DefaultServer.
+func SetCache(serviceMethod string, ttl time.Duration, size int) error {
+	return DefaultServer.SetCache(serviceMethod, ttl, size)
+}
+
+// resultCache returns the cache of m, nil if its replies aren't cached.
+func (m *methodType) resultCache() *resultCache {
+	if c, _ := m.cache.Load().(**resultCache); c != nil {
+		return *c
+	}
+	return nil
+}
+
+// Cached reports whether the replies of the method are cached.
+func (m *methodType) Cached() bool {
+	return m.resultCache() != nil
+}
+
+func (m *methodType) CacheHits() uint64 {
+	if c := m.resultCache(); c != nil {
+		return atomic.LoadUint64(&c.hits)
+	}
+	return 0
+}
+
+func (m *methodType) CacheMisses() uint64 {
+	if c := m.resultCache(); c != nil {
+		return atomic.LoadUint64(&c.misses)
+	}
+	return 0
+}
+
+// key returns the key of the reply of req, false if its args can't be
+// gob encoded, which is logged once. The args are encoded the way the gob
+// codec sends them, so that args differing in a field the method can
+// receive never share a reply; unexported fields and fields gob can't
+// send, such as chans and funcs, are never filled in by a codec anyway.
+// Maps are encoded in random order, args holding some may miss the cache.
+func (c *resultCache) key(req *request) (string, bool) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).EncodeValue(req.argv); err != nil {
+		c.keyErr.Do(func() {
+			log.Printf("rpc server: can't cache %s: %v\n", req.h.ServiceMethod, err)
+		})
+		return "", false
+	}
+	return buf.String(), true
+}
+
+type noCacheKey struct{}
+
+// WithNoCache returns a copy of ctx making the calls bypass the caches of
+// the servers.
+func WithNoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+func noCache(ctx context.Context) bool {
+	v, _ := ctx.Value(noCacheKey{}).(bool)
+	return v
+}
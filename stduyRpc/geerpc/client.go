@@ -13,6 +13,7 @@ import (
 	"strings"
 	"studyRpc/codec"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,6 +25,8 @@ type Call struct {
 	Reply         interface{} // 服务提供的方法的返回值
 	Error         error       // if error occurs, it will be set
 	Done          chan *Call  // 调用完成通知调用方
+	span          *Span       // span of a traced Client.Call
+	noCache       bool        // bypass the cache of the server, see WithNoCache
 }
 
 func (call *Call) done() {
@@ -46,8 +49,11 @@ type Client struct {
 	seq     uint64           //发送的请求编号
 	pending map[uint64]*Call //存储未处理完的请求，键是编号，值是 Call 实例
 	//closing 和 shutdown 任意一个值置为 true，则表示 Client 处于不可用的状态
-	closing  bool // 用户主动关闭
-	shutdown bool // 有错误发生
+	closing  bool         // 用户主动关闭
+	shutdown bool         // 有错误发生
+	ack      Ack          // 服务端的握手回复
+	peer     string       // 服务端地址，记录在 span 中
+	exporter atomic.Value // exporterValue, 见 SetExporter
 }
 
 var _ io.Closer = (*Client)(nil)
@@ -110,8 +116,9 @@ func (client *Client) terminateCalls(err error) {
 //接收响应
 func (client *Client) receive() {
 	var err error
+	var h codec.Header // reused, calls never keep the header
 	for err == nil {
-		var h codec.Header
+		h = codec.Header{}
 		if err = client.cc.ReadHeader(&h); err != nil {
 			break
 		}
@@ -153,7 +160,38 @@ func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 		_ = conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn), opt), nil
+	// wait for the server to accept them before sending any request
+	var ack Ack
+	if err := json.NewDecoder(conn).Decode(&ack); err != nil {
+		log.Println("rpc client: handshake error: ", err)
+		_ = conn.Close()
+		return nil, errors.New("rpc client: handshake error: " + err.Error())
+	}
+	if ack.Error != "" {
+		_ = conn.Close()
+		return nil, errors.New("rpc client: connection rejected by server: " + ack.Error)
+	}
+	client := newClientCodec(f(conn), opt)
+	client.ack = ack
+	if conn.RemoteAddr() != nil {
+		client.peer = conn.RemoteAddr().String()
+	}
+	return client, nil
+}
+
+// Supports reports whether the server announced feature in the handshake.
+func (client *Client) Supports(feature string) bool {
+	for _, f := range client.ack.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerVersion returns the protocol version announced by the server.
+func (client *Client) ServerVersion() int {
+	return client.ack.Version
 }
 
 func newClientCodec(cc codec.Codec, opt *Option) *Client {
@@ -177,6 +215,9 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	}
 	opt := opts[0]
 	opt.MagicNumber = DefaultOption.MagicNumber
+	if opt.Version == 0 {
+		opt.Version = DefaultOption.Version
+	}
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
@@ -188,7 +229,7 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	conn, err := dial(network, address, opt.ConnectTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -215,6 +256,13 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 	}
 }
 
+func dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	if network == "inproc" {
+		return dialInproc(address, timeout)
+	}
+	return net.DialTimeout(network, address, timeout)
+}
+
 // Dial connects to an RPC server at the specified network address
 func Dial(network, address string, opts ...*Option) (*Client, error) {
 	return dialTimeout(NewClient, network, address, opts...)
@@ -238,6 +286,9 @@ func (client *Client) send(call *Call) {
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.OneWay = false
+	setTrace(&client.header, call.span)
+	client.header.NoCache = call.noCache
 
 	// 编码并发送请求
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
@@ -252,6 +303,8 @@ func (client *Client) send(call *Call) {
 }
 
 // RPC 服务调用接口,异步
+// Calls made with Go aren't traced since there is no context to carry the
+// parent span, use Call or Batch for that.
 func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
@@ -271,13 +324,127 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 // RPC 服务调用接口,同步
 // send正常调用结束不也应该removeCall？？？
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	call := callPool.Get().(*Call)
+	call.ServiceMethod, call.Args, call.Reply = serviceMethod, args, reply
+	call.span = client.startSpan(ctx, serviceMethod)
+	call.noCache = noCache(ctx)
+	client.send(call)
 	select {
 	case <-ctx.Done():
 		client.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
-	case call := <-call.Done:
-		return call.Error
+		// receive may still be handling the call, so it isn't recycled
+		err := errors.New("rpc client: call failed: " + ctx.Err().Error())
+		client.finishSpan(call.span, err)
+		return err
+	case <-call.Done:
+		err := call.Error
+		client.finishSpan(call.span, err)
+		*call = Call{Done: call.Done}
+		callPool.Put(call)
+		return err
+	}
+}
+
+// callPool recycles the calls of Client.Call, which never escape to the caller.
+var callPool = sync.Pool{New: func() interface{} { return &Call{Done: make(chan *Call, 1)} }}
+
+// Notify sends a one-way request: the server runs serviceMethod without
+// replying, so no pending call is registered and Notify returns as soon
+// as the request is written. The span of ctx, if any, is propagated but
+// Notify records no span of its own, having no reply to wait for.
+func (client *Client) Notify(ctx context.Context, serviceMethod string, args interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return errors.New("rpc client: notify failed: " + err.Error())
+	}
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	if !client.IsAvailable() {
+		return ErrShutdown
+	}
+	client.header.ServiceMethod = serviceMethod
+	client.header.Seq = 0 // 0 means invalid call, a stray response is discarded by receive
+	client.header.Error = ""
+	client.header.OneWay = true
+	client.header.NoCache = false
+	setTrace(&client.header, SpanFromContext(ctx))
+	return client.cc.Write(&client.header, args)
+}
+
+// Batch sends all calls with a single flush and waits until every one is
+// done or ctx is canceled. The outcome of each call is reported in its
+// Error field, Batch returns the first of them or the context error.
+// The Done channel of the calls is replaced by Batch.
+func (client *Client) Batch(ctx context.Context, calls []*Call) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	done := make(chan *Call, len(calls))
+	for _, call := range calls {
+		call.Done = done
+		call.Error = nil
+		call.span = client.startSpan(ctx, call.ServiceMethod)
+		call.noCache = noCache(ctx)
+	}
+	client.sendBatch(calls)
+	for n := 0; n < len(calls); n++ {
+		select {
+		case <-ctx.Done():
+			err := errors.New("rpc client: batch failed: " + ctx.Err().Error())
+			for _, call := range calls {
+				client.removeCall(call.Seq)
+				client.finishSpan(call.span, err)
+			}
+			return err
+		case <-done:
+		}
+	}
+	for _, call := range calls {
+		client.finishSpan(call.span, call.Error)
+	}
+	for _, call := range calls {
+		if call.Error != nil {
+			return call.Error
+		}
+	}
+	return nil
+}
+
+func (client *Client) sendBatch(calls []*Call) {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+
+	hs := make([]*codec.Header, 0, len(calls))
+	bodies := make([]interface{}, 0, len(calls))
+	for _, call := range calls {
+		seq, err := client.registerCall(call)
+		if err != nil {
+			call.Error = err
+			call.done()
+			continue
+		}
+		h := &codec.Header{ServiceMethod: call.ServiceMethod, Seq: seq, NoCache: call.noCache}
+		setTrace(h, call.span)
+		hs = append(hs, h)
+		bodies = append(bodies, call.Args)
+	}
+	if len(hs) == 0 {
+		return
+	}
+	for i, h := range hs {
+		// split in batches of MaxBatch, still sent with a single flush
+		h.Batch = len(hs) - i/MaxBatch*MaxBatch
+		if h.Batch > MaxBatch {
+			h.Batch = MaxBatch
+		}
+	}
+	if err := codec.WriteBatch(client.cc, hs, bodies); err != nil {
+		for _, h := range hs {
+			// see send, the call may already be handled
+			if call := client.removeCall(h.Seq); call != nil {
+				call.Error = err
+				call.done()
+			}
+		}
 	}
 }
 
@@ -311,7 +478,7 @@ func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
 	default:
-		// tcp, unix or other transport protocol
+		// tcp, unix, inproc or other transport protocol
 		return Dial(protocol, addr, opts...)
 	}
 }
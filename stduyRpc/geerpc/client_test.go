@@ -2,6 +2,7 @@ package geerpc
 
 import (
 	"context"
+	"encoding/json"
 	"net"
 	"os"
 	"runtime"
@@ -88,3 +89,146 @@ func TestXDial(t *testing.T) {
 		_assert(err == nil, "failed to connect unix socket")
 	}
 }
+
+func TestClient_Notify(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	received := make(chan int, 2)
+	_ = server.RegisterFunc("Audit.Log", func(n int, reply *int) error {
+		received <- n
+		return nil
+	})
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+	t.Run("notify", func(t *testing.T) {
+		err := client.Notify(context.Background(), "Audit.Log", 1)
+		_assert(err == nil, "failed to notify: %v", err)
+		_assert(<-received == 1, "expect the notification to be handled")
+		client.mu.Lock()
+		pending := len(client.pending)
+		client.mu.Unlock()
+		_assert(pending == 0, "notify shouldn't register a pending call")
+	})
+	t.Run("one-way method", func(t *testing.T) {
+		_assert(server.SetOneWay("Audit.Log") == nil, "failed to mark Audit.Log as one-way")
+		var reply int
+		err := client.Call(context.Background(), "Audit.Log", 2, &reply)
+		_assert(err == nil && reply == 0, "expect an empty acknowledgement, got %v", err)
+		_assert(<-received == 2, "expect the one-way call to be handled")
+	})
+}
+
+func TestClient_Batch(t *testing.T) {
+	t.Parallel()
+	for _, coalesce := range []bool{false, true} {
+		server := NewServer()
+		server.CoalesceBatch = coalesce
+		var foo Foo
+		_ = server.Register(&foo)
+		l, _ := net.Listen("tcp", ":0")
+		go server.Accept(l)
+
+		client, _ := Dial("tcp", l.Addr().String())
+		replies := make([]int, 5)
+		calls := make([]*Call, 0, len(replies)+1)
+		for i := range replies {
+			calls = append(calls, &Call{ServiceMethod: "Foo.Sum", Args: Args{Num1: i, Num2: i * i}, Reply: &replies[i]})
+		}
+		calls = append(calls, &Call{ServiceMethod: "Foo.Unknown", Args: Args{}, Reply: new(int)})
+		err := client.Batch(context.Background(), calls)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect the error of Foo.Unknown, got %v", err)
+		for i, reply := range replies {
+			_assert(calls[i].Error == nil && reply == i+i*i, "wrong reply of call %d: %d, %v", i, reply, calls[i].Error)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestClient_BatchOneWay(t *testing.T) {
+	server := NewServer()
+	server.CoalesceBatch = true
+	var foo Foo
+	_ = server.Register(&foo)
+	release := make(chan struct{})
+	_ = server.RegisterFunc("Audit.Slow", func(n int, reply *int) error {
+		<-release
+		return nil
+	})
+	_ = server.SetOneWay("Audit.Slow")
+	client := startInproc(t, server, "geerpc-batch-oneway")
+	var sum, ack int
+	calls := []*Call{
+		{ServiceMethod: "Foo.Sum", Args: Args{Num1: 1, Num2: 2}, Reply: &sum},
+		{ServiceMethod: "Audit.Slow", Args: 1, Reply: &ack},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := client.Batch(ctx, calls)
+	close(release)
+	_assert(err == nil && sum == 3, "a slow one-way method shouldn't hold the batch back: %v", err)
+}
+
+func TestXDial_inproc(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, err := ListenInproc("geerpc-test")
+	_assert(err == nil, "failed to listen inproc: %v", err)
+	_, err = ListenInproc("geerpc-test")
+	_assert(err != nil, "expect an address in use error")
+	go server.Accept(l)
+
+	client, err := XDial("inproc@geerpc-test")
+	_assert(err == nil, "failed to connect inproc listener: %v", err)
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum over inproc: %v", err)
+
+	_ = l.Close()
+	_, err = XDial("inproc@geerpc-test")
+	_assert(err != nil, "expect a connection refused error")
+}
+
+func TestClient_handshake(t *testing.T) {
+	server := NewServer()
+	server.CoalesceBatch = true
+	serveInproc(t, server, "geerpc-handshake")
+
+	client, err := XDial("inproc@geerpc-handshake")
+	_assert(err == nil, "failed to connect: %v", err)
+	_assert(client.ServerVersion() == ProtocolVersion, "wrong server version %d", client.ServerVersion())
+	_assert(client.Supports(FeatureOneWay) && client.Supports(FeatureBatch), "expect oneway and batch features")
+
+	_, err = XDial("inproc@geerpc-handshake", &Option{Version: ProtocolVersion + 1})
+	_assert(err != nil && strings.Contains(err.Error(), "unsupported protocol version"), "expect a version error, got %v", err)
+
+	c1, c2 := net.Pipe()
+	go server.ServeConn(c2)
+	_ = json.NewEncoder(c1).Encode(&Option{MagicNumber: 1})
+	var ack Ack
+	err = json.NewDecoder(c1).Decode(&ack)
+	_assert(err == nil && strings.Contains(ack.Error, "invalid magic number"), "expect a magic number error, got %q", ack.Error)
+}
+
+func BenchmarkClient_Call(b *testing.B) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client := startInproc(b, server, "geerpc-bench")
+
+	ctx := context.Background()
+	args := &Args{Num1: 1, Num2: 2}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var reply int
+		for pb.Next() {
+			if err := client.Call(ctx, "Foo.Sum", args, &reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
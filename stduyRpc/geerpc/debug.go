@@ -14,11 +14,18 @@ const debugText = `<html>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Panics</th><th align=center>Cache hits</th><th align=center>Cache misses</th>
 		{{range $name, $mtype := .Method}}
 			<tr>
 			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
 			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=center>{{$mtype.NumPanics}}</td>
+			{{if $mtype.Cached}}
+			<td align=center>{{$mtype.CacheHits}}</td>
+			<td align=center>{{$mtype.CacheMisses}}</td>
+			{{else}}
+			<td align=center>-</td><td align=center>-</td>
+			{{end}}
 			</tr>
 		{{end}}
 		</table>
@@ -0,0 +1,126 @@
+package geerpc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"studyRpc/codec"
+)
+
+const (
+	defaultGatewayPath = "/rpc/"
+	maxHTTPBody        = 1 << 20 // bytes accepted in the body of a gateway or JSON-RPC request
+)
+
+// gatewayHTTP exposes the registered services as JSON endpoints:
+// POST /rpc/{Service}/{Method} with the args as body answers with the reply.
+type gatewayHTTP struct {
+	*Server
+}
+
+type gatewayError struct {
+	Error string `json:"error"`
+}
+
+// Runs at /rpc/
+func (server gatewayHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, gatewayError{"rpc gateway: must POST"})
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, defaultGatewayPath)
+	slash := strings.LastIndex(name, "/")
+	if slash < 0 {
+		writeJSON(w, http.StatusNotFound, gatewayError{"rpc gateway: path must be " + defaultGatewayPath + "{Service}/{Method}"})
+		return
+	}
+	serviceMethod := name[:slash] + "." + name[slash+1:]
+	r := &request{h: &codec.Header{ServiceMethod: serviceMethod}, peer: req.RemoteAddr}
+	var err error
+	r.svc, r.mtype, err = server.findService(serviceMethod)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, gatewayError{err.Error()})
+		return
+	}
+	r.argv = r.mtype.newArgv()
+	r.replyv = r.mtype.newReplyv()
+	// an empty body leaves args as the zero value
+	body := limitBody(w, req.Body, maxHTTPBody)
+	if err := json.NewDecoder(body).Decode(argvPtr(r.argv)); err != nil && !errors.Is(err, io.EOF) {
+		if body.exceeded {
+			writeJSON(w, http.StatusRequestEntityTooLarge, gatewayError{"rpc gateway: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, gatewayError{"rpc gateway: decoding args: " + err.Error()})
+		return
+	}
+	// the gateway has no connection, its calls only take server wide slots
+	slots, ok := server.tryAcquireSlot()
+	if !ok {
+		writeJSON(w, http.StatusServiceUnavailable, gatewayError{ErrServerBusy.Error()})
+		return
+	}
+	if r.mtype.IsOneWay() {
+		w.WriteHeader(http.StatusAccepted)
+		go func() {
+			defer release(slots)
+			server.invoke(r, server.GatewayTimeout)
+		}()
+		return
+	}
+	reply := server.invoke(r, server.GatewayTimeout)
+	release(slots)
+	switch {
+	case r.detached:
+		writeJSON(w, http.StatusGatewayTimeout, gatewayError{r.h.Error})
+	case r.h.Error != "":
+		writeJSON(w, http.StatusInternalServerError, gatewayError{r.h.Error})
+	default:
+		writeJSON(w, http.StatusOK, reply)
+	}
+}
+
+// limitedBody is a request body capped by http.MaxBytesReader that
+// remembers whether the cap was hit.
+type limitedBody struct {
+	io.ReadCloser
+	left     int64 // bytes that can still be read
+	exceeded bool
+}
+
+func limitBody(w http.ResponseWriter, body io.ReadCloser, n int64) *limitedBody {
+	return &limitedBody{ReadCloser: http.MaxBytesReader(w, body, n), left: n}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	if err != nil && err != io.EOF && b.left <= 0 {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("rpc gateway: write response error:", err)
+	}
+}
+
+// HandleGateway registers the JSON gateway of server on /rpc/.
+// It is still necessary to invoke http.Serve(), typically in a go statement.
+func (server *Server) HandleGateway() {
+	http.Handle(defaultGatewayPath, gatewayHTTP{server})
+	log.Println("rpc server gateway path:", defaultGatewayPath)
+}
+
+// HandleGateway is a convenient approach for default server to register the gateway
+func HandleGateway() {
+	DefaultServer.HandleGateway()
+}
@@ -0,0 +1,62 @@
+package geerpc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGateway(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.RegisterFunc("Math.Fail", func(args Args, reply *int) error {
+		return errors.New("always fails")
+	})
+	tests := []struct {
+		method, path, body string
+		status             int
+		resp               string
+	}{
+		{"POST", "/rpc/Foo/Sum", `{"Num1":1,"Num2":2}`, http.StatusOK, "3\n"},
+		{"GET", "/rpc/Foo/Sum", "", http.StatusMethodNotAllowed, ""},
+		{"POST", "/rpc/Foo/Mul", "{}", http.StatusNotFound, ""},
+		{"POST", "/rpc/Foo", "{}", http.StatusNotFound, ""},
+		{"POST", "/rpc/Foo/Sum", `{"Num1":"x"}`, http.StatusBadRequest, ""},
+		{"POST", "/rpc/Math/Fail", "{}", http.StatusInternalServerError, `{"error":"always fails"}` + "\n"},
+		{"POST", "/rpc/Foo/Sum", `{"Num1":"` + strings.Repeat("1", maxHTTPBody) + `"}`, http.StatusRequestEntityTooLarge, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		gatewayHTTP{server}.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		_assert(w.Code == tt.status, "%s %s: expect status %d, got %d", tt.method, tt.path, tt.status, w.Code)
+		_assert(tt.resp == "" || w.Body.String() == tt.resp, "%s %s: unexpected body %q", tt.method, tt.path, w.Body.String())
+	}
+}
+
+func TestGateway_limits(t *testing.T) {
+	server := NewServer()
+	server.MaxConcurrent = 1
+	server.GatewayTimeout = 100 * time.Millisecond
+	running, gate := make(chan struct{}), make(chan struct{})
+	_ = server.RegisterFunc("Slow.Wait", func(n int, reply *int) error {
+		running <- struct{}{}
+		<-gate
+		return nil
+	})
+	post := func() int {
+		w := httptest.NewRecorder()
+		gatewayHTTP{server}.ServeHTTP(w, httptest.NewRequest("POST", "/rpc/Slow/Wait", strings.NewReader("1")))
+		return w.Code
+	}
+
+	done := make(chan int)
+	go func() { done <- post() }()
+	<-running
+	_assert(post() == http.StatusServiceUnavailable, "expect the gateway to be busy")
+	_assert(<-done == http.StatusGatewayTimeout, "expect the call to time out")
+	close(gate)
+}
@@ -0,0 +1,95 @@
+package geerpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// inproc 协议：同一进程内通过 net.Pipe 连接客户端和服务端，不占用 socket，
+// 地址格式为 inproc@name
+
+type inprocAddr string
+
+func (a inprocAddr) Network() string { return "inproc" }
+func (a inprocAddr) String() string  { return string(a) }
+
+type inprocListener struct {
+	name  inprocAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+var (
+	inprocMu        sync.Mutex // protect inprocListeners
+	inprocListeners = make(map[string]*inprocListener)
+)
+
+var errInprocClosed = errors.New("rpc inproc: listener closed")
+
+// ListenInproc announces an in-process listener under name. Servers Accept
+// on it like on any net.Listener and clients reach it with XDial("inproc@"+name).
+func ListenInproc(name string) (net.Listener, error) {
+	inprocMu.Lock()
+	defer inprocMu.Unlock()
+	if _, dup := inprocListeners[name]; dup {
+		return nil, fmt.Errorf("rpc inproc: listen %s: address already in use", name)
+	}
+	l := &inprocListener{
+		name:  inprocAddr(name),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	inprocListeners[name] = l
+	return l, nil
+}
+
+func (l *inprocListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errInprocClosed
+	}
+}
+
+// Close stops the listener and releases its name, accepted connections stay open.
+func (l *inprocListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		inprocMu.Lock()
+		delete(inprocListeners, string(l.name))
+		inprocMu.Unlock()
+	})
+	return nil
+}
+
+func (l *inprocListener) Addr() net.Addr { return l.name }
+
+// dialInproc connects to the in-process listener name, 0 timeout means no limit.
+func dialInproc(name string, timeout time.Duration) (net.Conn, error) {
+	inprocMu.Lock()
+	l := inprocListeners[name]
+	inprocMu.Unlock()
+	if l == nil {
+		return nil, fmt.Errorf("rpc inproc: dial %s: connection refused", name)
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, fmt.Errorf("rpc inproc: dial %s: connection refused", name)
+	case <-expired:
+		return nil, fmt.Errorf("rpc inproc: dial %s: timeout", name)
+	}
+}
@@ -0,0 +1,87 @@
+package geerpc
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"studyRpc/codec"
+)
+
+const defaultJSONRPCPath = "/jsonrpc"
+
+// ServeJSONRPC serves JSON-RPC 2.0 requests on conn until the client hangs up.
+// Unlike ServeConn there is no Option handshake, requests start right away.
+func (server *Server) ServeJSONRPC(conn io.ReadWriteCloser) {
+	defer func() { _ = conn.Close() }()
+	server.serveCodec(codec.NewJSONRPCCodec(conn), Option{}, remoteAddr(conn))
+}
+
+// AcceptJSONRPC accepts connections on the listener and serves
+// JSON-RPC 2.0 requests for each incoming connection.
+func (server *Server) AcceptJSONRPC(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Println("rpc server: accept error:", err)
+			return
+		}
+		go server.ServeJSONRPC(conn)
+	}
+}
+
+// jsonrpcHTTP answers JSON-RPC 2.0 requests sent as the body of a POST.
+type jsonrpcHTTP struct {
+	*Server
+}
+
+// bodyConn reads the request body and buffers the responses.
+type bodyConn struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (c *bodyConn) Read(p []byte) (int, error) { return c.Reader.Read(p) }
+
+func (c *bodyConn) Close() error { return nil }
+
+// Runs at /jsonrpc
+func (server jsonrpcHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = io.WriteString(w, "405 must POST\n")
+		return
+	}
+	body := limitBody(w, req.Body, maxHTTPBody)
+	conn := &bodyConn{Reader: body}
+	// serveCodec returns once the body is consumed and all requests are answered
+	server.serveCodec(codec.NewJSONRPCCodec(conn), Option{}, req.RemoteAddr)
+	if body.exceeded {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		_, _ = io.WriteString(w, "413 request body too large\n")
+		return
+	}
+	if conn.Len() == 0 {
+		// only notifications
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = conn.WriteTo(w)
+}
+
+// HandleJSONRPC registers an HTTP handler for JSON-RPC 2.0 requests on /jsonrpc.
+// It is still necessary to invoke http.Serve(), typically in a go statement.
+func (server *Server) HandleJSONRPC() {
+	http.Handle(defaultJSONRPCPath, jsonrpcHTTP{server})
+	log.Println("rpc server jsonrpc path:", defaultJSONRPCPath)
+}
+
+// HandleJSONRPC is a convenient approach for default server to register the JSON-RPC handler
+func HandleJSONRPC() {
+	DefaultServer.HandleJSONRPC()
+}
@@ -0,0 +1,84 @@
+package geerpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONRPC_HTTP(t *testing.T) {
+	server := NewServer()
+	server.CoalesceBatch = true
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.RegisterFunc("List.Sum", func(nums []int, reply *int) error {
+		for _, n := range nums {
+			*reply += n
+		}
+		return nil
+	})
+	_ = server.RegisterFunc("Math.Fail", func(args Args, reply *int) error {
+		return errors.New("rpc server: can't find the answer")
+	})
+	tests := []struct {
+		name, body string
+		status     int
+		resp       string
+	}{
+		{"call", `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`, http.StatusOK,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{"positional params", `{"jsonrpc":"2.0","method":"Foo.Sum","params":[{"Num1":2,"Num2":2}],"id":"a"}`, http.StatusOK,
+			`{"jsonrpc":"2.0","result":4,"id":"a"}`},
+		{"positional list", `{"jsonrpc":"2.0","method":"List.Sum","params":[5],"id":"b"}`, http.StatusOK,
+			`{"jsonrpc":"2.0","result":5,"id":"b"}`},
+		{"method error", `{"jsonrpc":"2.0","method":"Math.Fail","params":{},"id":5}`, http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"rpc server: can't find the answer"},"id":5}`},
+		{"notification", `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1}}`, http.StatusNoContent, ""},
+		{"method not found", `{"jsonrpc":"2.0","method":"Foo.Mul","id":2}`, http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"rpc server: can't find method Mul"},"id":2}`},
+		{"invalid params", `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":"x"},"id":3}`, http.StatusOK,
+			`"code":-32602`},
+		{"parse error", `{"jsonrpc":`, http.StatusOK, `"code":-32700`},
+		{"invalid request", `{"method":"Foo.Sum","id":4}`, http.StatusOK, `"code":-32600`},
+		{"too large", `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":"` + strings.Repeat("1", maxHTTPBody) + `"},"id":6}`,
+			http.StatusRequestEntityTooLarge, ""},
+		{"batch", `[{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1},"id":1},
+			{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":5}},
+			{"jsonrpc":"1.0","method":"Foo.Sum","id":3},
+			{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":2},"id":2}]`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		jsonrpcHTTP{server}.ServeHTTP(w, httptest.NewRequest("POST", "/jsonrpc", strings.NewReader(tt.body)))
+		_assert(w.Code == tt.status, "%s: expect status %d, got %d", tt.name, tt.status, w.Code)
+		_assert(strings.Contains(w.Body.String(), tt.resp), "%s: unexpected response %q", tt.name, w.Body.String())
+		if tt.name == "batch" {
+			var resps []map[string]interface{}
+			err := json.Unmarshal(w.Body.Bytes(), &resps)
+			_assert(err == nil && len(resps) == 3, "expect 3 responses in a single array, got %q", w.Body.String())
+		}
+	}
+}
+
+func TestJSONRPC_TCP(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.AcceptJSONRPC(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	for i := 1; i <= 3; i++ {
+		_, _ = conn.Write([]byte(`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":1},"id":1}` + "\n"))
+		line, err := r.ReadString('\n')
+		_assert(err == nil && line == `{"jsonrpc":"2.0","result":2,"id":1}`+"\n", "unexpected response %q, %v", line, err)
+	}
+}
@@ -0,0 +1,172 @@
+package geerpc
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerBusy is reported to clients when a request exceeds the
+// concurrency limits and the queue of its connection is full.
+var ErrServerBusy = errors.New("rpc server: server busy")
+
+// workerPool bounds the requests served concurrently on a connection,
+// see Server.MaxConcurrentPerConn, Server.MaxConcurrent and Server.QueueSize.
+// A nil *workerPool means no limit.
+type workerPool struct {
+	conn      chan struct{} // per connection slots, nil if unlimited
+	global    chan struct{} // server wide slots, nil if unlimited
+	queue     chan func()   // jobs waiting for a slot
+	queueSize int32
+	queued    int32 // jobs in queue, accessed atomically
+}
+
+func (server *Server) newWorkerPool() *workerPool {
+	if server.MaxConcurrentPerConn <= 0 && server.MaxConcurrent <= 0 {
+		return nil
+	}
+	p := &workerPool{queueSize: int32(server.QueueSize)}
+	if server.MaxConcurrentPerConn > 0 {
+		p.conn = make(chan struct{}, server.MaxConcurrentPerConn)
+	}
+	if server.MaxConcurrent > 0 {
+		server.slotsOnce.Do(func() { server.slots = make(chan struct{}, server.MaxConcurrent) })
+		p.global = server.slots
+	}
+	if p.queueSize > 0 {
+		p.queue = make(chan func(), p.queueSize)
+		go p.dispatch()
+	}
+	return p
+}
+
+// run starts job in a new goroutine if a slot is free, otherwise queues it.
+// It returns false, without running job, if the queue is full.
+func (p *workerPool) run(job func()) bool {
+	if p == nil {
+		go job()
+		return true
+	}
+	// don't overtake queued jobs
+	if atomic.LoadInt32(&p.queued) == 0 && p.tryAcquire() {
+		go p.work(job)
+		return true
+	}
+	if atomic.AddInt32(&p.queued, 1) > p.queueSize {
+		atomic.AddInt32(&p.queued, -1)
+		return false
+	}
+	p.queue <- job // never blocks, queued <= cap(queue)
+	return true
+}
+
+// dispatch starts the queued jobs as slots become free.
+func (p *workerPool) dispatch() {
+	for job := range p.queue {
+		p.acquire()
+		atomic.AddInt32(&p.queued, -1)
+		go p.work(job)
+	}
+}
+
+func (p *workerPool) work(job func()) {
+	defer release(p.global)
+	defer release(p.conn)
+	job()
+}
+
+// acquire waits for both slots. It never holds one while blocked on the
+// other, so a connection waiting for a server wide slot doesn't keep its
+// own slots from the jobs that finish meanwhile, and the other way round.
+func (p *workerPool) acquire() {
+	for {
+		acquire(p.conn)
+		if tryAcquire(p.global) {
+			return
+		}
+		release(p.conn)
+		acquire(p.global)
+		if tryAcquire(p.conn) {
+			return
+		}
+		release(p.global)
+	}
+}
+
+func (p *workerPool) tryAcquire() bool {
+	if !tryAcquire(p.conn) {
+		return false
+	}
+	if !tryAcquire(p.global) {
+		release(p.conn)
+		return false
+	}
+	return true
+}
+
+// tryAcquireSlot takes a server wide slot for a request served outside of
+// a connection, it returns the slots to release or false if none is free.
+func (server *Server) tryAcquireSlot() (chan struct{}, bool) {
+	if server.MaxConcurrent <= 0 {
+		return nil, true
+	}
+	server.slotsOnce.Do(func() { server.slots = make(chan struct{}, server.MaxConcurrent) })
+	if !tryAcquire(server.slots) {
+		return nil, false
+	}
+	return server.slots, true
+}
+
+// waitSlot reports whether a server wide slot is free or frees up within
+// d, without keeping it.
+func (server *Server) waitSlot(d time.Duration) bool {
+	slots, ok := server.tryAcquireSlot()
+	if ok {
+		release(slots)
+		return true
+	}
+	if d <= 0 {
+		return false
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case server.slots <- struct{}{}:
+		release(server.slots)
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// close stops accepting jobs, the queued ones still run.
+func (p *workerPool) close() {
+	if p != nil && p.queue != nil {
+		close(p.queue)
+	}
+}
+
+// tryAcquire takes a slot if one is free, nil slots are unlimited.
+func tryAcquire(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func acquire(slots chan struct{}) {
+	if slots != nil {
+		slots <- struct{}{}
+	}
+}
+
+func release(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"net"
 	"net/http"
 	"reflect"
+	"runtime"
 	"strings"
 	"studyRpc/codec"
 	"sync"
@@ -23,21 +24,78 @@ type methodType struct {
 	ArgType   reflect.Type   //第一个参数的类型
 	ReplyType reflect.Type   //第二个参数的类型
 	numCalls  uint64         //调用次数
+	numPanics uint64         //调用发生 panic 的次数
+	oneWay    uint32         //非 0 表示服务端从不回复该方法的结果
+	withCtx   bool           //第一个参数是 context.Context
+	cache     atomic.Value   //**resultCache，见 SetCache
+
+	fn      func(ctx context.Context, argv, replyv reflect.Value) error // precomputed call, see bind
+	argPool sync.Pool                                                   // reusable storage of value args
 }
 
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
+
+func (m *methodType) NumPanics() uint64 {
+	return atomic.LoadUint64(&m.numPanics)
+}
+
+// IsOneWay reports whether the method was marked with Server.SetOneWay.
+func (m *methodType) IsOneWay() bool {
+	return atomic.LoadUint32(&m.oneWay) != 0
+}
+
 //newArgv和newReplyv待研究
 func (m *methodType) newArgv() reflect.Value {
-	var argv reflect.Value
 	// arg may be a pointer type, or a value type
 	if m.ArgType.Kind() == reflect.Ptr {
-		argv = reflect.New(m.ArgType.Elem()) //Elem() Type 返回Type的元素类型
-	} else {
-		argv = reflect.New(m.ArgType).Elem() //func (v Value) Elem() Value，返回v指向的值
+		return reflect.New(m.ArgType.Elem()) //Elem() Type 返回Type的元素类型
+	}
+	// 值类型的参数在调用时会被复制，方法无法持有它的存储，可以复用
+	if p := m.argPool.Get(); p != nil {
+		return reflect.ValueOf(p).Elem()
+	}
+	return reflect.New(m.ArgType).Elem() //func (v Value) Elem() Value，返回v指向的值
+}
+
+// freeArgv makes argv, returned by newArgv, available for reuse.
+func (m *methodType) freeArgv(argv reflect.Value) {
+	if m.ArgType.Kind() == reflect.Ptr {
+		return
+	}
+	argv.Set(reflect.Zero(m.ArgType)) // decoders leave absent fields untouched
+	m.argPool.Put(argv.Addr().Interface())
+}
+
+// bind precomputes the call of the method on rcvr,
+// rcvr is the zero Value for functions.
+func (m *methodType) bind(rcvr reflect.Value) {
+	f := m.method.Func
+	result := func(out []reflect.Value) error {
+		if errInter := out[0].Interface(); errInter != nil {
+			return errInter.(error)
+		}
+		return nil
+	}
+	switch {
+	case !rcvr.IsValid() && !m.withCtx:
+		m.fn = func(_ context.Context, argv, replyv reflect.Value) error {
+			return result(f.Call([]reflect.Value{argv, replyv}))
+		}
+	case !rcvr.IsValid():
+		m.fn = func(ctx context.Context, argv, replyv reflect.Value) error {
+			return result(f.Call([]reflect.Value{reflect.ValueOf(&ctx).Elem(), argv, replyv}))
+		}
+	case !m.withCtx:
+		m.fn = func(_ context.Context, argv, replyv reflect.Value) error {
+			return result(f.Call([]reflect.Value{rcvr, argv, replyv}))
+		}
+	default:
+		m.fn = func(ctx context.Context, argv, replyv reflect.Value) error {
+			return result(f.Call([]reflect.Value{rcvr, reflect.ValueOf(&ctx).Elem(), argv, replyv}))
+		}
 	}
-	return argv
 }
 
 func (m *methodType) newReplyv() reflect.Value {
@@ -55,20 +113,48 @@ func (m *methodType) newReplyv() reflect.Value {
 type service struct {
 	name   string                 //映射的结构体的名称
 	typ    reflect.Type           //结构体的类型
-	rcvr   reflect.Value          //结构体的实例本身
+	rcvr   reflect.Value          //结构体的实例本身，函数注册的服务为零值
 	method map[string]*methodType //存储映射的结构体的所有符合条件的方法
 }
+
 //newService入参是任意需要映射为服务的结构体实例
-func newService(rcvr interface{}) *service {
+func newService(rcvr interface{}) (*service, error) {
+	if err := checkReceiver(rcvr); err != nil {
+		return nil, err
+	}
+	name := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	if !ast.IsExported(name) {
+		return nil, fmt.Errorf("rpc server: %s is not a valid service name", name)
+	}
+	return newServiceName(name, rcvr)
+}
+
+// newServiceName is like newService but publishes rcvr under the given name.
+func newServiceName(name string, rcvr interface{}) (*service, error) {
+	if err := checkReceiver(rcvr); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("rpc server: no service name for type " + reflect.TypeOf(rcvr).String())
+	}
 	s := new(service)
+	s.name = name
 	s.rcvr = reflect.ValueOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
 	s.typ = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
-	}
 	s.registerMethods()
-	return s
+	if len(s.method) == 0 {
+		return nil, fmt.Errorf("rpc server: type %s has no exported methods of suitable type", s.typ)
+	}
+	return s, nil
+}
+
+// checkReceiver rejects nil receivers, whose methods can't be called.
+func checkReceiver(rcvr interface{}) error {
+	v := reflect.ValueOf(rcvr)
+	if !v.IsValid() || v.Kind() == reflect.Ptr && v.IsNil() {
+		return fmt.Errorf("rpc server: can't register a nil %T", rcvr)
+	}
+	return nil
 }
 
 //过滤出了符合条件的方法
@@ -76,40 +162,91 @@ func (s *service) registerMethods() {
 	s.method = make(map[string]*methodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
 		//两个导出或内置类型的入参（反射时为 3 个，第 0 个是自身,类似C++中的this指针）
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
-			continue
-		}
-		//返回值有且只有 1 个，类型为 error,使用 Elem()方法获取指针对应的值
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
-		}
-		argType, replyType := mType.In(1), mType.In(2) //返回函数类型的第i个输入参数的类型
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		mType, err := newMethodType(method, 1)
+		if err != nil {
 			continue
 		}
-		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
-		}
+		mType.bind(s.rcvr)
+		s.method[method.Name] = mType
 		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
 }
 
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// newMethodType checks that method has the form
+// func ([ctx context.Context,] args T1, reply *T2) error, where the first
+// skip inputs (the receiver) are ignored.
+func newMethodType(method reflect.Method, skip int) (*methodType, error) {
+	mType := method.Type
+	// 可选的 context.Context 参数，携带超时取消和 trace 信息
+	withCtx := mType.NumIn() > skip && mType.In(skip) == typeOfContext
+	if withCtx {
+		skip++
+	}
+	if mType.NumIn() != skip+2 || mType.NumOut() != 1 {
+		return nil, fmt.Errorf("rpc server: method %s has wrong number of ins or outs", method.Name)
+	}
+	//返回值有且只有 1 个，类型为 error,使用 Elem()方法获取指针对应的值
+	if mType.Out(0) != typeOfError {
+		return nil, fmt.Errorf("rpc server: method %s returns %s, not error", method.Name, mType.Out(0))
+	}
+	argType, replyType := mType.In(skip), mType.In(skip+1) //返回函数类型的第i个输入参数的类型
+	if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		return nil, fmt.Errorf("rpc server: method %s has unexported argument or reply type", method.Name)
+	}
+	if replyType.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("rpc server: reply type of method %s is not a pointer: %s", method.Name, replyType)
+	}
+	return &methodType{
+		method:    method,
+		ArgType:   argType,
+		ReplyType: replyType,
+		withCtx:   withCtx,
+	}, nil
+}
+
 func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
 func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+	return s.callContext(context.Background(), m, argv, replyv)
+}
+
+// callContext is like call, ctx is passed to methods taking a context.Context.
+func (s *service) callContext(ctx context.Context, m *methodType, argv, replyv reflect.Value) (err error) {
 	atomic.AddUint64(&m.numCalls, 1)
-	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
-	if errInter := returnValues[0].Interface(); errInter != nil {
-		return errInter.(error)
-	}
-	return nil
+	// a panicking method must not crash the whole server
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&m.numPanics, 1)
+			stack := make([]byte, 64<<10)
+			stack = stack[:runtime.Stack(stack, false)]
+			pe := &panicError{serviceMethod: s.name + "." + m.method.Name, value: r, stack: stack}
+			log.Printf("rpc server: panic in %s: %v\n%s", pe.serviceMethod, r, pe.stack)
+			err = pe
+		}
+	}()
+	return m.fn(ctx, argv, replyv)
+}
+
+// panicError reports a panic recovered in a service method.
+type panicError struct {
+	serviceMethod string
+	value         interface{}
+	stack         []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("rpc server: internal error: panic in %s: %v", e.serviceMethod, e.value)
 }
 
 const MagicNumber = 0x3bef5c
@@ -120,6 +257,7 @@ const MagicNumber = 0x3bef5c
 
 type Option struct {
 	MagicNumber    int           // MagicNumber marks this's a geerpc request
+	Version        int           // protocol version of the client, 0 is read as 1
 	CodecType      codec.Type    // client may choose different Codec to encode body
 	ConnectTimeout time.Duration // 0 means no limit
 	HandleTimeout  time.Duration
@@ -127,12 +265,69 @@ type Option struct {
 
 var DefaultOption = &Option{
 	MagicNumber:    MagicNumber,
+	Version:        ProtocolVersion,
 	CodecType:      codec.GobType,
 	ConnectTimeout: time.Second * 10,
 }
 
+// ProtocolVersion is the version of the protocol spoken after the handshake.
+const ProtocolVersion = 1
+
+// Features a server may announce in its Ack.
+const (
+	FeatureOneWay  = "oneway"  // Client.Notify and one-way methods
+	FeatureBatch   = "batch"   // batches are served concurrently and answered with one flush
+	FeatureTracing = "tracing" // trace ids in the header are propagated to the methods
+)
+
+// MaxBatch is the largest batch a server coalesces, Client.Batch splits
+// larger ones.
+const MaxBatch = 1024
+
+// Ack is the server's answer to the Option sent by the client, the
+// connection switches to CodecType only if Error is empty.
+type Ack struct {
+	Version   int        // protocol version of the server
+	CodecType codec.Type // accepted codec
+	Features  []string   // supported features, see FeatureOneWay...
+	Error     string     // reason of the rejection
+}
+
 // Server represents an RPC Server.
 type Server struct {
+	// CoalesceBatch makes the server run the requests of a batch sent by
+	// Client.Batch concurrently and write all their responses with a
+	// single flush. Otherwise they are served like separate requests.
+	// The requests of a batch larger than MaxBatch are answered with
+	// ErrServerBusy.
+	CoalesceBatch bool
+
+	// MaxConcurrentPerConn and MaxConcurrent bound the requests
+	// handled at the same time on one connection and on the whole server,
+	// 0 means no limit.
+	MaxConcurrentPerConn int
+	MaxConcurrent        int
+	// QueueSize is the number of requests per connection that may wait for
+	// a free slot once a limit is reached, the requests beyond it are
+	// answered with ErrServerBusy.
+	QueueSize int
+	// GatewayTimeout bounds the calls made through the HTTP gateway, which
+	// has no Option to carry a HandleTimeout. 0 means no limit.
+	GatewayTimeout time.Duration
+	// RethrowPanics makes a panic in a service method crash the server
+	// after it is logged, instead of being reported to the client.
+	// Meant for debugging.
+	RethrowPanics bool
+	// Exporter, if set, receives a span for every request the server handles.
+	Exporter Exporter
+
+	slots     chan struct{} // server wide slots for MaxConcurrent
+	slotsOnce sync.Once
+
+	builtin     *service // see builtinService
+	builtinOnce sync.Once
+
+	mu         sync.Mutex // serializes registration
 	serviceMap sync.Map
 }
 
@@ -174,8 +369,78 @@ func HandleHTTP() {
 }
 
 // Register publishes in the server the set of methods of the
+// receiver value that satisfy the following conditions:
+//   - exported method of exported type
+//   - two arguments, both of exported type, optionally preceded by a
+//     context.Context that carries the handle timeout and the trace span
+//   - the second argument is a pointer
+//   - one return value, of type error
+//
+// It returns an error if the receiver is not an exported type or has
+// no suitable methods.
 func (server *Server) Register(rcvr interface{}) error {
-	s := newService(rcvr)
+	s, err := newService(rcvr)
+	if err != nil {
+		return err
+	}
+	return server.register(s)
+}
+
+// RegisterName is like Register but uses the provided name for the type
+// instead of the receiver's concrete type.
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	s, err := newServiceName(name, rcvr)
+	if err != nil {
+		return err
+	}
+	return server.register(s)
+}
+
+// RegisterFunc publishes fn as serviceMethod ("Service.Method"). fn must
+// follow the same rules as a method without receiver,
+// func(args T1, reply *T2) error. Several functions may share the same service name, but a service
+// registered from a receiver can't be extended with functions.
+func (server *Server) RegisterFunc(serviceMethod string, fn interface{}) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot <= 0 || dot == len(serviceMethod)-1 {
+		return errors.New("rpc server: service/method ill-formed: " + serviceMethod)
+	}
+	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func {
+		return fmt.Errorf("rpc server: %s is not a function: %T", serviceMethod, fn)
+	}
+	mType, err := newMethodType(reflect.Method{Name: methodName, Type: fv.Type(), Func: fv}, 0)
+	if err != nil {
+		return err
+	}
+	mType.bind(reflect.Value{})
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	// services are read without locking, so copy on write instead of
+	// adding the method to a published service in place
+	s := &service{name: serviceName, method: map[string]*methodType{methodName: mType}}
+	if svci, ok := server.serviceMap.Load(serviceName); ok {
+		old := svci.(*service)
+		if old.rcvr.IsValid() {
+			return errors.New("rpc: service already defined: " + serviceName)
+		}
+		if _, dup := old.method[methodName]; dup {
+			return errors.New("rpc: method already defined: " + serviceMethod)
+		}
+		for name, m := range old.method {
+			s.method[name] = m
+		}
+	}
+	server.serviceMap.Store(serviceName, s)
+	log.Printf("rpc server: register %s\n", serviceMethod)
+	return nil
+}
+
+func (server *Server) register(s *service) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
 		return errors.New("rpc: service already defined: " + s.name)
 	}
@@ -185,6 +450,14 @@ func (server *Server) Register(rcvr interface{}) error {
 // Register publishes the receiver's methods in the DefaultServer.
 func Register(rcvr interface{}) error { return DefaultServer.Register(rcvr) }
 
+// RegisterName is like Register but uses the provided name for the type.
+func RegisterName(name string, rcvr interface{}) error { return DefaultServer.RegisterName(name, rcvr) }
+
+// RegisterFunc publishes fn as serviceMethod in the DefaultServer.
+func RegisterFunc(serviceMethod string, fn interface{}) error {
+	return DefaultServer.RegisterFunc(serviceMethod, fn)
+}
+
 func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
@@ -192,12 +465,14 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
-	svci, ok := server.serviceMap.Load(serviceName)
-	if !ok {
+	if serviceName == BuiltinService {
+		svc = server.builtinService()
+	} else if svci, ok := server.serviceMap.Load(serviceName); ok {
+		svc = svci.(*service)
+	} else {
 		err = errors.New("rpc server: can't find service " + serviceName)
 		return
 	}
-	svc = svci.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
 		err = errors.New("rpc server: can't find method " + methodName)
@@ -205,6 +480,18 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 	return
 }
 
+// SetOneWay marks serviceMethod as one-way: the server never sends its
+// result. Requests made with Client.Call are acknowledged with an empty
+// reply before the method runs, Client.Notify gets no response at all.
+func (server *Server) SetOneWay(serviceMethod string) error {
+	_, mtype, err := server.findService(serviceMethod)
+	if err != nil {
+		return err
+	}
+	atomic.StoreUint32(&mtype.oneWay, 1)
+	return nil
+}
+
 // NewServer returns a new Server.
 func NewServer() *Server {
 	return &Server{}
@@ -233,26 +520,61 @@ func (server *Server) Accept(lis net.Listener) {
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }()
 	var opt Option
+	ack := Ack{Version: ProtocolVersion}
 	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
 		log.Println("rpc server: options error: ", err)
-		return
+		ack.Error = "invalid options: " + err.Error()
+	} else {
+		ack = server.ack(&opt)
 	}
-	if opt.MagicNumber != MagicNumber {
-		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
+	// 回复握手结果，客户端据此判断是否可以开始发送请求
+	if err := json.NewEncoder(conn).Encode(&ack); err != nil {
+		log.Println("rpc server: ack error: ", err)
 		return
 	}
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+	if ack.Error != "" {
+		log.Println("rpc server: reject connection:", ack.Error)
 		return
 	}
-	server.serveCodec(f(conn), opt)
+	server.serveCodec(codec.NewCodecFuncMap[opt.CodecType](conn), opt, remoteAddr(conn))
+}
+
+// remoteAddr returns the address of the peer of conn, if known.
+func remoteAddr(conn io.ReadWriteCloser) string {
+	if c, ok := conn.(net.Conn); ok && c.RemoteAddr() != nil {
+		return c.RemoteAddr().String()
+	}
+	return ""
+}
+
+// ack checks the options sent by a client.
+func (server *Server) ack(opt *Option) Ack {
+	ack := Ack{Version: ProtocolVersion, CodecType: opt.CodecType}
+	switch {
+	case opt.MagicNumber != MagicNumber:
+		ack.Error = fmt.Sprintf("invalid magic number %x", opt.MagicNumber)
+	case opt.Version > ProtocolVersion:
+		ack.Error = fmt.Sprintf("unsupported protocol version %d, server speaks %d", opt.Version, ProtocolVersion)
+	case codec.NewCodecFuncMap[opt.CodecType] == nil:
+		ack.Error = fmt.Sprintf("invalid codec type %s", opt.CodecType)
+	default:
+		ack.Features = server.features()
+	}
+	return ack
+}
+
+func (server *Server) features() []string {
+	features := []string{FeatureOneWay, FeatureTracing}
+	if server.CoalesceBatch {
+		features = append(features, FeatureBatch)
+	}
+	return features
 }
 
 // invalidRequest is a placeholder for response argv when error occurs
 var invalidRequest = struct{}{}
 
-func (server *Server) serveCodec(cc codec.Codec, opt Option) {
+func (server *Server) serveCodec(cc codec.Codec, opt Option, peer string) {
 	// golang里文件描述符(FD)的写入已经是线程安全的了
 	//加锁是为了避免缓冲区 c.buf.Flush() 的时候，其他goroutine也在往同一个缓冲区写入，从而导致 err: short write的错误。
 	//（假设不使用缓冲区，就不会有这种问题，但是会牺牲一部分buffer带来的性能优化）
@@ -260,41 +582,135 @@ func (server *Server) serveCodec(cc codec.Codec, opt Option) {
 
 	//同步，等待所有请求处理完
 	wg := new(sync.WaitGroup)
+	pool := server.newWorkerPool()
 	//只有readRequest发生错误才会退出循环，等待其它请求响应完毕后关闭连接
 	for {
 		req, err := server.readRequest(cc)
+		if req == nil {
+			break // it's not possible to recover, so close the connection
+		}
+		req.peer = peer
 		if err != nil {
-			if req == nil {
-				break // it's not possible to recover, so close the connection
-			}
 			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
+		}
+		if server.CoalesceBatch && req.h.Batch > MaxBatch {
+			// don't hold the batch in memory, reject its requests as they come
+			if !server.rejectBatch(cc, req, sending, wg) {
+				break
+			}
+			continue
+		}
+		if server.CoalesceBatch && req.h.Batch > 1 {
+			batch, ok := server.readBatch(cc, req)
+			server.handleBatch(cc, pool, batch, sending, wg, opt.HandleTimeout)
+			if !ok {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			if !req.h.OneWay {
+				server.sendResponse(cc, req.h, invalidRequest, sending)
+			}
+			server.freeRequest(req)
 			continue
 		}
 		wg.Add(1)
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		// health checks bypass the limits, a busy server is still alive
+		if pool == nil || req.h.ServiceMethod == HealthMethod {
+			go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		} else if !pool.run(func() { server.handleRequest(cc, req, sending, wg, opt.HandleTimeout) }) {
+			server.reject(cc, []*request{req}, sending, wg)
+		}
 	}
+	pool.close()
 	wg.Wait()
 	_ = cc.Close()
 }
 
+// reject answers requests that didn't get a worker with ErrServerBusy.
+func (server *Server) reject(cc codec.Codec, reqs []*request, sending *sync.Mutex, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var hs []*codec.Header
+	var bodies []interface{}
+	for _, req := range reqs {
+		if !req.h.OneWay {
+			req.h.Error = ErrServerBusy.Error()
+			hs = append(hs, req.h)
+			bodies = append(bodies, invalidRequest)
+		}
+	}
+	if len(hs) > 0 {
+		sending.Lock()
+		if err := codec.WriteBatch(cc, hs, bodies); err != nil {
+			log.Println("rpc server: write response error:", err)
+		}
+		sending.Unlock()
+	}
+	for _, req := range reqs {
+		server.freeRequest(req)
+	}
+}
+
+// rejectBatch answers the batch started by first with ErrServerBusy,
+// reading its requests one at a time. It returns false if the connection
+// broke before the whole batch was read.
+func (server *Server) rejectBatch(cc codec.Codec, first *request, sending *sync.Mutex, wg *sync.WaitGroup) bool {
+	n := first.h.Batch
+	req := first
+	for i := 1; ; i++ {
+		wg.Add(1)
+		server.reject(cc, []*request{req}, sending, wg)
+		if i == n {
+			return true
+		}
+		if req, _ = server.readRequest(cc); req == nil {
+			return false
+		}
+	}
+}
+
 // request stores all information of a call
 type request struct {
 	h            *codec.Header // header of request
 	argv, replyv reflect.Value // argv and replyv of request
 	mtype        *methodType
 	svc          *service
+	detached     bool   // the method still runs after a handle timeout, so req can't be reused
+	peer         string // address of the client
+}
+
+// 复用请求头和 request，减少每个请求的内存分配
+var (
+	headerPool  = sync.Pool{New: func() interface{} { return new(codec.Header) }}
+	requestPool = sync.Pool{New: func() interface{} { return new(request) }}
+)
+
+// freeRequest recycles req once its response is written.
+func (server *Server) freeRequest(req *request) {
+	if req.detached {
+		return
+	}
+	if req.mtype != nil && req.argv.IsValid() {
+		req.mtype.freeArgv(req.argv)
+	}
+	*req.h = codec.Header{}
+	headerPool.Put(req.h)
+	*req = request{}
+	requestPool.Put(req)
 }
 
 func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
-	var h codec.Header
-	if err := cc.ReadHeader(&h); err != nil {
+	h := headerPool.Get().(*codec.Header)
+	if err := cc.ReadHeader(h); err != nil {
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
 			log.Println("rpc server: read header error:", err)
 		}
+		*h = codec.Header{}
+		headerPool.Put(h)
 		return nil, err
 	}
-	return &h, nil
+	return h, nil
 }
 
 func (server *Server) readRequest(cc codec.Codec) (*request, error) {
@@ -302,25 +718,52 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	if err != nil {
 		return nil, err
 	}
-	req := &request{h: h}
+	req := requestPool.Get().(*request)
+	req.h = h
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
 	if err != nil {
+		_ = cc.ReadBody(nil) // discard the body to keep the stream in sync
+		h.ErrorKind = codec.ErrorMethodNotFound
 		return req, err
 	}
 	req.argv = req.mtype.newArgv()
 	req.replyv = req.mtype.newReplyv()
-	//确保argvi的值为指针类型，以达到ReadBody时能够同步改变req中的argv
-	argvi := req.argv.Interface()
-	if req.argv.Type().Kind() != reflect.Ptr {
-		argvi = req.argv.Addr().Interface()
-	}
-	if err = cc.ReadBody(argvi); err != nil {
+	if err = cc.ReadBody(argvPtr(req.argv)); err != nil {
 		log.Println("rpc server: read body err:", err)
+		h.ErrorKind = codec.ErrorInvalidArgs
 		return req, err
 	}
 	return req, nil
 }
 
+// readBatch reads the rest of the batch started by first, requests that
+// can't be served carry the error in their header. ok is false if the
+// connection broke before the whole batch was read.
+func (server *Server) readBatch(cc codec.Codec, first *request) (reqs []*request, ok bool) {
+	reqs = []*request{first}
+	for len(reqs) < first.h.Batch {
+		req, err := server.readRequest(cc)
+		if req == nil {
+			return reqs, false
+		}
+		req.peer = first.peer
+		if err != nil {
+			req.h.Error = err.Error()
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, true
+}
+
+// argvPtr returns argv as a pointer, so that decoding into it changes argv.
+func argvPtr(argv reflect.Value) interface{} {
+	//确保argvi的值为指针类型，以达到ReadBody时能够同步改变req中的argv
+	if argv.Type().Kind() != reflect.Ptr {
+		return argv.Addr().Interface()
+	}
+	return argv.Interface()
+}
+
 func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
 	sending.Lock()
 	defer sending.Unlock()
@@ -331,38 +774,168 @@ func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interfa
 
 func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
 	defer wg.Done()
-	called := make(chan struct{}) //接收到消息，代表处理没有超时，继续执行 sendResponse
-	sent := make(chan struct{})   //成功返回响应
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go func(ctx context.Context) {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
-		called <- struct{}{}
-		if err != nil {
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
-			sent <- struct{}{}
-			return
+	if req.mtype.IsOneWay() && !req.h.OneWay {
+		// the caller still waits for a reply, acknowledge before running the method
+		server.sendResponse(cc, req.h, req.mtype.newReplyv().Interface(), sending)
+		req.h.OneWay = true
+	}
+	body := server.invoke(req, timeout)
+	if !req.h.OneWay {
+		server.sendResponse(cc, req.h, body, sending)
+	}
+	server.freeRequest(req)
+}
+
+// handleBatch runs the requests of a batch concurrently, each of them
+// taking a slot of pool, and writes all the responses with a single flush
+// once every request is done. The requests that don't get a slot are
+// answered with ErrServerBusy. One-way requests don't hold the responses
+// back, one-way methods are acknowledged before they run.
+func (server *Server) handleBatch(cc codec.Codec, pool *workerPool, reqs []*request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+	hs := make([]*codec.Header, len(reqs))
+	bodies := make([]interface{}, len(reqs))
+	detached := make([]bool, len(reqs)) // one-way requests, freed once their method returns
+	var calls, oneWays sync.WaitGroup
+	for i, req := range reqs {
+		if req.h.Error != "" {
+			hs[i], bodies[i] = req.h, invalidRequest
+			continue
 		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
-		sent <- struct{}{}
-		select {
-		case <-ctx.Done(): //防止goroutines泄露
-			return
+		if req.mtype.IsOneWay() || req.h.OneWay {
+			var ack *codec.Header
+			var ackBody interface{}
+			if !req.h.OneWay {
+				h := *req.h
+				ack, ackBody = &h, req.mtype.newReplyv().Interface()
+				req.h.OneWay = true
+			}
+			oneWays.Add(1)
+			req := req
+			if !pool.run(func() {
+				defer oneWays.Done()
+				server.invoke(req, timeout)
+				server.freeRequest(req)
+			}) {
+				oneWays.Done()
+				if ack != nil {
+					ack.Error = ErrServerBusy.Error()
+					hs[i], bodies[i] = ack, invalidRequest
+				}
+				continue
+			}
+			detached[i] = true
+			if ack != nil {
+				hs[i], bodies[i] = ack, ackBody
+			}
+			continue
+		}
+		hs[i] = req.h
+		calls.Add(1)
+		i, req := i, req
+		if !pool.run(func() {
+			defer calls.Done()
+			bodies[i] = server.invoke(req, timeout)
+		}) {
+			calls.Done()
+			req.h.Error = ErrServerBusy.Error()
+			bodies[i] = invalidRequest
+		}
+	}
+	// write the responses once the calls are done, without holding the reader
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		calls.Wait()
+
+		var replyHs []*codec.Header
+		var replyBodies []interface{}
+		for i, h := range hs {
+			if h != nil && !h.OneWay {
+				replyHs = append(replyHs, h)
+				replyBodies = append(replyBodies, bodies[i])
+			}
+		}
+		if len(replyHs) > 0 {
+			sending.Lock()
+			if err := codec.WriteBatch(cc, replyHs, replyBodies); err != nil {
+				log.Println("rpc server: write batch response error:", err)
+			}
+			sending.Unlock()
 		}
-	}(ctx)
+		for i, req := range reqs {
+			if !detached[i] {
+				server.freeRequest(req)
+			}
+		}
+		oneWays.Wait()
+	}()
+}
+
+// invoke calls the service method of req and returns the response body,
+// the error, if any, is reported in req.h.Error. The body comes from the
+// cache of the method if it has one, a cache hit still counts as a call
+// and gets a span.
+func (server *Server) invoke(req *request, timeout time.Duration) interface{} {
+	ctx := context.Background()
+	if span := server.startSpan(req); span != nil {
+		ctx = ContextWithSpan(ctx, span)
+		defer func() { server.finishSpan(span, req.h.Error) }()
+	}
+	cache := req.mtype.resultCache()
+	if cache == nil {
+		return server.invokeMethod(ctx, req, timeout)
+	}
+	key, ok := cache.key(req)
+	if !ok {
+		return server.invokeMethod(ctx, req, timeout)
+	}
+	if !req.h.NoCache {
+		if reply, hit := cache.get(key); hit {
+			atomic.AddUint64(&req.mtype.numCalls, 1)
+			return reply
+		}
+	}
+	body := server.invokeMethod(ctx, req, timeout)
+	if req.h.Error == "" {
+		cache.put(key, body) // replies aren't recycled, it can be sent again
+	}
+	return body
+}
+
+func (server *Server) invokeMethod(ctx context.Context, req *request, timeout time.Duration) interface{} {
 	//timeout为0代表无限制
 	if timeout == 0 {
-		<-called
-		<-sent
-		return
+		return server.result(req, server.call(ctx, req))
 	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	called := make(chan error, 1) // 带缓冲，超时后 call 结束也不会阻塞，避免 goroutines 泄露
+	go func() {
+		called <- server.call(ctx, req)
+	}()
 	select {
 	case <-time.After(timeout):
-		//如果在timeout后call才调用结束，但已经超时，直接返回，将不会接受called，存在goroutines泄露
+		req.detached = true
 		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		server.sendResponse(cc, req.h, invalidRequest, sending)
-	case <-called:
-		<-sent
+		return invalidRequest
+	case err := <-called:
+		return server.result(req, err)
+	}
+}
+
+// call runs the method of req, recovered panics are rethrown if the server is set to.
+func (server *Server) call(ctx context.Context, req *request) error {
+	err := req.svc.callContext(ctx, req.mtype, req.argv, req.replyv)
+	if pe, ok := err.(*panicError); ok && server.RethrowPanics {
+		panic(pe.value)
+	}
+	return err
+}
+
+func (server *Server) result(req *request, err error) interface{} {
+	if err != nil {
+		req.h.Error = err.Error()
+		return invalidRequest
 	}
+	return req.replyv.Interface()
 }
@@ -0,0 +1,402 @@
+package geerpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"studyRpc/codec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveInproc serves server on the in-process listener name until the
+// test ends.
+func serveInproc(tb testing.TB, server *Server, name string) {
+	l, err := ListenInproc(name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+}
+
+// connectInproc connects to the in-process listener name, the client is
+// closed when the test ends.
+func connectInproc(tb testing.TB, name string, opt ...*Option) *Client {
+	client, err := XDial("inproc@"+name, opt...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// startInproc serves server on the in-process listener name and returns
+// a client connected to it, both are closed when the test ends.
+func startInproc(tb testing.TB, server *Server, name string, opt ...*Option) *Client {
+	serveInproc(tb, server, name)
+	return connectInproc(tb, name, opt...)
+}
+
+func TestServer_busy(t *testing.T) {
+	server := NewServer()
+	server.MaxConcurrentPerConn = 1
+	server.QueueSize = 1
+	gate := make(chan struct{})
+	_ = server.RegisterFunc("Slow.Wait", func(n int, reply *int) error {
+		<-gate
+		*reply = n
+		return nil
+	})
+	client := startInproc(t, server, "geerpc-busy")
+	var replies [3]int
+	running := client.Go("Slow.Wait", 1, &replies[0], nil)
+	queued := client.Go("Slow.Wait", 2, &replies[1], nil)
+	err := client.Call(context.Background(), "Slow.Wait", 3, &replies[2])
+	_assert(err != nil && err.Error() == ErrServerBusy.Error(), "expect the server to be busy, got %v", err)
+	var status string
+	err = client.Call(context.Background(), HealthMethod, "", &status)
+	_assert(err == nil && status == HealthServing, "expect a busy server to pass health checks, got %v", err)
+
+	close(gate)
+	_assert((<-running.Done).Error == nil && replies[0] == 1, "running call failed")
+	_assert((<-queued.Done).Error == nil && replies[1] == 2, "queued call failed")
+}
+
+func TestServer_busyBatch(t *testing.T) {
+	server := NewServer()
+	server.CoalesceBatch = true
+	server.MaxConcurrentPerConn = 1
+	var foo Foo
+	_ = server.Register(&foo)
+	gate := make(chan struct{})
+	_ = server.RegisterFunc("Slow.Wait", func(n int, reply *int) error {
+		<-gate
+		*reply = n
+		return nil
+	})
+	client := startInproc(t, server, "geerpc-busy-batch")
+	calls := make([]*Call, 3)
+	for i := range calls {
+		calls[i] = &Call{ServiceMethod: "Slow.Wait", Args: i, Reply: new(int)}
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(gate)
+	}()
+	_ = client.Batch(context.Background(), calls)
+	_assert(calls[0].Error == nil && *calls[0].Reply.(*int) == 0, "first call failed: %v", calls[0].Error)
+	for _, call := range calls[1:] {
+		_assert(call.Error != nil && call.Error.Error() == ErrServerBusy.Error(), "expect each request of a batch to take a slot, got %v", call.Error)
+	}
+
+	// a batch larger than MaxBatch is rejected without being coalesced
+	done := make(chan *Call, MaxBatch+1)
+	var hs []*codec.Header
+	var bodies []interface{}
+	for i := 0; i <= MaxBatch; i++ {
+		call := &Call{ServiceMethod: "Foo.Sum", Args: Args{Num1: i}, Reply: new(int), Done: done}
+		seq, _ := client.registerCall(call)
+		hs = append(hs, &codec.Header{ServiceMethod: "Foo.Sum", Seq: seq, Batch: MaxBatch + 1})
+		bodies = append(bodies, call.Args)
+	}
+	client.sending.Lock()
+	_ = codec.WriteBatch(client.cc, hs, bodies)
+	client.sending.Unlock()
+	for i := 0; i <= MaxBatch; i++ {
+		call := <-done
+		_assert(call.Error != nil && call.Error.Error() == ErrServerBusy.Error(), "expect an oversized batch to be rejected, got %v", call.Error)
+	}
+
+	// Client.Batch splits it instead
+	server.MaxConcurrentPerConn = 0
+	client2 := connectInproc(t, "geerpc-busy-batch")
+	calls = make([]*Call, MaxBatch+1)
+	for i := range calls {
+		calls[i] = &Call{ServiceMethod: "Foo.Sum", Args: Args{Num1: i}, Reply: new(int)}
+	}
+	err := client2.Batch(context.Background(), calls)
+	_assert(err == nil && *calls[MaxBatch].Reply.(*int) == MaxBatch, "expect a large batch to be split, got %v", err)
+}
+
+func TestServer_busyGlobal(t *testing.T) {
+	server := NewServer()
+	server.MaxConcurrent = 1
+	server.MaxConcurrentPerConn = 1
+	server.QueueSize = 3
+	var running, maxRunning int32
+	_ = server.RegisterFunc("Slow.Wait", func(n int, reply *int) error {
+		if r := atomic.AddInt32(&running, 1); r > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, r)
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		*reply = n
+		return nil
+	})
+	serveInproc(t, server, "geerpc-busy-global")
+
+	var calls []*Call
+	for i := 0; i < 2; i++ {
+		client := connectInproc(t, "geerpc-busy-global")
+		for j := 0; j < 3; j++ {
+			calls = append(calls, client.Go("Slow.Wait", j, new(int), nil))
+		}
+	}
+	for _, call := range calls {
+		call = <-call.Done
+		_assert(call.Error == nil && *call.Reply.(*int) == call.Args.(int), "call failed: %v", call.Error)
+	}
+	_assert(atomic.LoadInt32(&maxRunning) == 1, "expect at most 1 running call, got %d", maxRunning)
+}
+
+func TestServer_panic(t *testing.T) {
+	server := NewServer()
+	_ = server.RegisterFunc("Bad.Panic", func(n int, reply *int) error {
+		panic("boom")
+	})
+	client := startInproc(t, server, "geerpc-panic")
+	var reply int
+	err := client.Call(context.Background(), "Bad.Panic", 1, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "internal error: panic in Bad.Panic: boom"), "expect an internal error, got %v", err)
+	_, mtype, _ := server.findService("Bad.Panic")
+	_assert(mtype.NumPanics() == 1, "expect 1 panic, got %d", mtype.NumPanics())
+	_assert(client.IsAvailable(), "the connection should survive the panic")
+
+	server.RethrowPanics = true
+	req := &request{h: &codec.Header{}}
+	req.svc, req.mtype, _ = server.findService("Bad.Panic")
+	req.argv, req.replyv = req.mtype.newArgv(), req.mtype.newReplyv()
+	defer func() {
+		_assert(recover() == "boom", "expect the panic to be rethrown")
+	}()
+	server.invoke(req, 0)
+}
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (r *spanRecorder) Export(span *Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func TestServer_trace(t *testing.T) {
+	server := NewServer()
+	server.Exporter = &spanRecorder{}
+	traces := make(chan *Span, 1)
+	_ = server.RegisterFunc("Trace.Echo", func(ctx context.Context, n int, reply *int) error {
+		traces <- SpanFromContext(ctx)
+		*reply = n
+		return nil
+	})
+	serveInproc(t, server, "geerpc-trace")
+
+	exporter := &spanRecorder{}
+	client := connectInproc(t, "geerpc-trace")
+	client.SetExporter(exporter)
+	var reply int
+	err := client.Call(context.Background(), "Trace.Echo", 1, &reply)
+	_assert(err == nil && reply == 1, "call failed: %v", err)
+
+	serverSpan := <-traces
+	_assert(len(exporter.spans) == 1, "expect 1 client span, got %d", len(exporter.spans))
+	clientSpan := exporter.spans[0]
+	_assert(clientSpan.Kind == SpanClient && clientSpan.ParentID == "", "unexpected client span %+v", clientSpan)
+	_assert(serverSpan != nil && serverSpan.Kind == SpanServer, "expect the method to get the server span")
+	_assert(serverSpan.TraceID == clientSpan.TraceID && serverSpan.ParentID == clientSpan.SpanID,
+		"server span %+v isn't a child of %+v", serverSpan, clientSpan)
+
+	// a call made while serving joins the trace of the request
+	ctx := ContextWithSpan(context.Background(), serverSpan)
+	_ = client.Call(ctx, "Trace.Echo", 2, &reply)
+	child := <-traces
+	_assert(child.TraceID == serverSpan.TraceID, "expect the trace to be propagated")
+	_assert(exporter.spans[1].ParentID == serverSpan.SpanID, "expect the client span to be a child of the context span")
+
+	// batched calls carry the trace too
+	err = client.Batch(ctx, []*Call{{ServiceMethod: "Trace.Echo", Args: 3, Reply: &reply}})
+	_assert(err == nil && reply == 3, "batch failed: %v", err)
+	batched := <-traces
+	_assert(len(exporter.spans) == 3, "expect a client span for the batched call, got %d", len(exporter.spans))
+	_assert(batched.TraceID == serverSpan.TraceID && batched.ParentID == exporter.spans[2].SpanID,
+		"batched server span %+v isn't a child of %+v", batched, exporter.spans[2])
+}
+
+func TestServer_health(t *testing.T) {
+	server := NewServer()
+	client := startInproc(t, server, "geerpc-health")
+	var status string
+	err := client.Call(context.Background(), HealthMethod, "", &status)
+	_assert(err == nil && status == HealthServing, "expect the server to be serving, got %q %v", status, err)
+	err = client.Call(context.Background(), BuiltinService+".Nope", "", &status)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find method Nope"), "expect an unknown method, got %v", err)
+
+	// a server whose slots are all held by a stuck method is busy
+	server.MaxConcurrent = 1
+	gate := make(chan struct{})
+	_ = server.RegisterFunc("Slow.Wait", func(n int, reply *int) error {
+		<-gate
+		return nil
+	})
+	client = connectInproc(t, "geerpc-health")
+	stuck := client.Go("Slow.Wait", 1, new(int), nil)
+	deadline := time.Now().Add(time.Second)
+	for status != HealthBusy {
+		_assert(time.Now().Before(deadline), "expect a wedged server to be busy, got %q", status)
+		_ = client.Call(context.Background(), HealthMethod, "10ms", &status)
+	}
+	close(gate)
+	<-stuck.Done
+	err = client.Call(context.Background(), HealthMethod, "1s", &status)
+	_assert(err == nil && status == HealthServing, "expect the server to serve again, got %q %v", status, err)
+	err = client.Call(context.Background(), HealthMethod, "soon", &status)
+	_assert(err != nil, "expect an invalid wait error")
+}
+
+func TestServer_jsonCodec(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	client := startInproc(t, server, "geerpc-json", &Option{CodecType: codec.JsonType})
+	var sum int
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "expect 3, got %d %v", sum, err)
+	// JSON args reach the method as if encoded by the caller
+	err = client.Call(context.Background(), "Foo.Sum", json.RawMessage(`{"Num1":2,"Num2":3}`), &sum)
+	_assert(err == nil && sum == 5, "expect 5, got %d %v", sum, err)
+	err = client.Call(context.Background(), "Foo.Nope", Args{}, &sum)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect an error, got %v", err)
+
+	var echo json.RawMessage
+	err = client.Call(context.Background(), EchoMethod, json.RawMessage(`{"a":[1,2]}`), &echo)
+	_assert(err == nil && string(echo) == `{"a":[1,2]}`, "expect the args back, got %s %v", echo, err)
+}
+
+func TestServer_services(t *testing.T) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	_ = server.RegisterFunc("Log.Write", func(msg string, reply *int) error { return nil })
+	_ = server.SetOneWay("Log.Write")
+	client := startInproc(t, server, "geerpc-services", &Option{CodecType: codec.JsonType})
+	var methods []MethodInfo
+	err := client.Call(context.Background(), ServicesMethod, "", &methods)
+	_assert(err == nil && len(methods) == 2, "expect 2 methods, got %+v %v", methods, err)
+	_assert(methods[0].Name == "Foo.Sum" && methods[0].Args == `{"Num1":0,"Num2":0}` && methods[0].ReplyType == "*int",
+		"unexpected method %+v", methods[0])
+	_assert(methods[1].Name == "Log.Write" && methods[1].OneWay, "unexpected method %+v", methods[1])
+}
+
+func TestServer_SetCache(t *testing.T) {
+	server := NewServer()
+	var calls int64
+	_ = server.RegisterFunc("Clock.Now", func(n int, reply *int64) error {
+		*reply = atomic.AddInt64(&calls, 1)
+		return nil
+	})
+	_assert(server.SetCache("Clock.Now", time.Minute, 2) == nil, "set cache failed")
+	exporter := &spanRecorder{}
+	server.Exporter = exporter
+	client := startInproc(t, server, "geerpc-cache")
+	now := func(ctx context.Context, n int) int64 {
+		var reply int64
+		err := client.Call(ctx, "Clock.Now", n, &reply)
+		_assert(err == nil, "call failed: %v", err)
+		return reply
+	}
+	ctx := context.Background()
+	_assert(now(ctx, 1) == 1 && now(ctx, 1) == 1, "expect the second call to hit the cache")
+	_assert(now(ctx, 2) == 2, "expect other args to miss the cache")
+	_assert(now(WithNoCache(ctx), 1) == 3, "expect the cache to be bypassed")
+	_assert(now(ctx, 1) == 3, "expect the bypassing call to refresh the cache")
+	_ = now(ctx, 3) // evicts 2, the least recently used
+	_assert(now(ctx, 2) == 5, "expect 2 to be evicted")
+
+	_, mtype, _ := server.findService("Clock.Now")
+	_assert(mtype.CacheHits() == 2 && mtype.CacheMisses() == 4, "unexpected hits %d, misses %d", mtype.CacheHits(), mtype.CacheMisses())
+	_assert(mtype.NumCalls() == 7, "expect the hits to count as calls, got %d", mtype.NumCalls())
+	exporter.mu.Lock()
+	_assert(len(exporter.spans) == 7, "expect a span for every call, got %d", len(exporter.spans))
+	exporter.mu.Unlock()
+	var page strings.Builder
+	_ = debug.Execute(&page, []debugService{{Name: "Clock", Method: map[string]*methodType{"Now": mtype}}})
+	cells := regexp.MustCompile(`<td align=center>([^<]*)</td>`).FindAllStringSubmatch(page.String(), -1)
+	_assert(len(cells) == 4 && cells[0][1] == "7" && cells[2][1] == "2" && cells[3][1] == "4", "expect the calls, hits and misses on the debug page, got %q", cells)
+
+	_ = server.SetCache("Clock.Now", 0, 0)
+	_assert(now(ctx, 1) == 6, "expect caching to be turned off")
+}
+
+type NoteArgs struct {
+	N    int
+	Note string `json:"-"`
+}
+
+func TestServer_SetCache_key(t *testing.T) {
+	server := NewServer()
+	_ = server.RegisterFunc("Note.Echo", func(args NoteArgs, reply *string) error {
+		*reply = args.Note
+		return nil
+	})
+	var calls int64
+	_ = server.RegisterFunc("Math.Abs", func(c complex128, reply *int64) error {
+		*reply = atomic.AddInt64(&calls, 1)
+		return nil
+	})
+	_assert(server.SetCache("Note.Echo", time.Minute, 0) == nil, "set cache failed")
+	_assert(server.SetCache("Math.Abs", time.Minute, 0) == nil, "set cache failed")
+	client := startInproc(t, server, "geerpc-cache-key")
+	ctx := context.Background()
+	for _, note := range []string{"a", "b"} {
+		var reply string
+		err := client.Call(ctx, "Note.Echo", NoteArgs{N: 1, Note: note}, &reply)
+		_assert(err == nil && reply == note, "expect args differing in a json:\"-\" field not to share a reply, got %q, %v", reply, err)
+	}
+	for i := 0; i < 2; i++ {
+		var reply int64
+		err := client.Call(ctx, "Math.Abs", complex(3, 4), &reply)
+		_assert(err == nil && reply == 1, "expect complex args to be cached, got %d, %v", reply, err)
+	}
+}
+
+// replayCodec feeds n Foo.Sum requests to the server and drops the
+// responses, so that only the server side is measured.
+type replayCodec struct {
+	n   int
+	seq uint64
+}
+
+func (c *replayCodec) ReadHeader(h *codec.Header) error {
+	if c.n == 0 {
+		return io.EOF
+	}
+	c.n--
+	c.seq++
+	*h = codec.Header{ServiceMethod: "Foo.Sum", Seq: c.seq}
+	return nil
+}
+
+func (c *replayCodec) ReadBody(body interface{}) error {
+	if args, ok := body.(*Args); ok {
+		*args = Args{Num1: 1, Num2: 2}
+	}
+	return nil
+}
+
+func (c *replayCodec) Write(*codec.Header, interface{}) error { return nil }
+
+func (c *replayCodec) Close() error { return nil }
+
+func BenchmarkServer_serveCodec(b *testing.B) {
+	server := NewServer()
+	_ = server.Register(new(Foo))
+	b.ReportAllocs()
+	b.ResetTimer()
+	server.serveCodec(&replayCodec{n: b.N}, Option{}, "")
+}
@@ -1,6 +1,7 @@
 package geerpc
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"testing"
@@ -29,7 +30,8 @@ func _assert(condition bool, msg string, v ...interface{}) {
 
 func TestNewService(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, err := newService(&foo)
+	_assert(err == nil, "failed to create service: %v", err)
 	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
 	mType := s.method["Sum"]
 	_assert(mType != nil, "wrong Method, Sum shouldn't nil")
@@ -37,12 +39,73 @@ func TestNewService(t *testing.T) {
 
 func TestMethodType_Call(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, _ := newService(&foo)
 	mType := s.method["Sum"]
 
 	argv := mType.newArgv()
 	replyv := mType.newReplyv()
-	argv.Elem().Set(reflect.ValueOf(Args{Num1: 1, Num2: 3})) //如果argv是指针型参数，set会Panic
+	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
 	err := s.call(mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
+
+type unexported int
+
+func (u unexported) Sum(args Args, reply *int) error { return nil }
+
+func TestServer_Register(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "failed to register Foo")
+	_assert(server.Register(&foo) != nil, "expect a duplicate service error")
+	_assert(server.RegisterName("Foo2", &foo) == nil, "failed to register Foo as Foo2")
+	var u unexported
+	_assert(server.Register(&u) != nil, "expect an invalid service name error")
+	_assert(server.RegisterName("Bar", &u) == nil, "failed to register unexported type by name")
+	_assert(server.Register(nil) != nil, "expect a nil receiver error")
+	_assert(server.RegisterName("Foo3", (*Foo)(nil)) != nil, "expect a nil pointer receiver error")
+
+	_, mtype, err := server.findService("Foo2.Sum")
+	_assert(err == nil && mtype != nil, "failed to find Foo2.Sum")
+}
+
+func TestServer_RegisterFunc(t *testing.T) {
+	server := NewServer()
+	mul := func(args Args, reply *int) error {
+		*reply = args.Num1 * args.Num2
+		return nil
+	}
+	_assert(server.RegisterFunc("Math.Mul", mul) == nil, "failed to register Math.Mul")
+	_assert(server.RegisterFunc("Math.Mul", mul) != nil, "expect a duplicate method error")
+	_assert(server.RegisterFunc("Math.Add", func(args Args, reply *int) error { return nil }) == nil, "failed to register Math.Add")
+	_assert(server.RegisterFunc("Math", mul) != nil, "expect an ill-formed name error")
+	_assert(server.RegisterFunc("Math.Bad", func(args Args) error { return nil }) != nil, "expect a signature error")
+
+	svc, mtype, err := server.findService("Math.Mul")
+	_assert(err == nil && len(svc.method) == 2, "expect 2 methods in Math, got %v", err)
+	argv, replyv := mtype.newArgv(), mtype.newReplyv()
+	argv.Set(reflect.ValueOf(Args{Num1: 3, Num2: 4}))
+	err = svc.call(mtype, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*int) == 12, "failed to call Math.Mul")
+
+	var foo Foo
+	_ = server.Register(&foo)
+	_assert(server.RegisterFunc("Foo.Mul", mul) != nil, "expect a service already defined error")
+}
+
+func TestServer_RegisterFuncContext(t *testing.T) {
+	server := NewServer()
+	type ctxKey struct{}
+	get := func(ctx context.Context, args Args, reply *string) error {
+		*reply, _ = ctx.Value(ctxKey{}).(string)
+		return nil
+	}
+	_assert(server.RegisterFunc("Ctx.Get", get) == nil, "failed to register Ctx.Get")
+
+	svc, mtype, err := server.findService("Ctx.Get")
+	_assert(err == nil, "failed to find Ctx.Get: %v", err)
+	argv, replyv := mtype.newArgv(), mtype.newReplyv()
+	ctx := context.WithValue(context.Background(), ctxKey{}, "geerpc")
+	err = svc.callContext(ctx, mtype, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*string) == "geerpc", "ctx not passed to Ctx.Get: %v", err)
+}
@@ -0,0 +1,181 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"studyRpc/codec"
+	"sync"
+	"time"
+)
+
+// Span kinds
+const (
+	SpanClient = "client" // a Client.Call
+	SpanServer = "server" // the server handling a request
+)
+
+// Span records one side of a call. The spans of a request that goes
+// through several geerpc hops share the same TraceID, each server span
+// is the child of the client span that sent the request.
+type Span struct {
+	TraceID       string        `json:"trace_id"`
+	SpanID        string        `json:"span_id"`
+	ParentID      string        `json:"parent_id,omitempty"`
+	Kind          string        `json:"kind"`
+	ServiceMethod string        `json:"service_method"`
+	Peer          string        `json:"peer,omitempty"`
+	Start         time.Time     `json:"start"`
+	Duration      time.Duration `json:"duration"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// Exporter receives the finished spans, it must be safe for concurrent use.
+type Exporter interface {
+	Export(span *Span)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span, calls made with
+// this context become its children.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, nil if none. Methods
+// taking a context.Context get the span of the request they serve.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// newSpan starts a span, child of parent if it is not nil.
+func newSpan(kind, serviceMethod, peer, traceID, parentID string) *Span {
+	if traceID == "" {
+		traceID = newID(16)
+	}
+	return &Span{
+		TraceID:       traceID,
+		SpanID:        newID(8),
+		ParentID:      parentID,
+		Kind:          kind,
+		ServiceMethod: serviceMethod,
+		Peer:          peer,
+		Start:         time.Now(),
+	}
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// finish records the duration and error of span and exports it.
+func (span *Span) finish(exporter Exporter, err string) {
+	if exporter == nil {
+		return
+	}
+	span.Duration = time.Since(span.Start)
+	span.Error = err
+	exporter.Export(span)
+}
+
+// startSpan starts the server span of req, nil if the request isn't traced
+// and the server has no exporter.
+func (server *Server) startSpan(req *request) *Span {
+	if req.h.TraceID == "" && server.Exporter == nil {
+		return nil
+	}
+	return newSpan(SpanServer, req.h.ServiceMethod, req.peer, req.h.TraceID, req.h.SpanID)
+}
+
+func (server *Server) finishSpan(span *Span, err string) {
+	span.finish(server.Exporter, err)
+}
+
+// startSpan starts the span of a call made with ctx, nil if ctx carries no
+// span and the client has no exporter.
+func (client *Client) startSpan(ctx context.Context, serviceMethod string) *Span {
+	parent := SpanFromContext(ctx)
+	if parent == nil && client.exporterOf() == nil {
+		return nil
+	}
+	if parent == nil {
+		return newSpan(SpanClient, serviceMethod, client.peer, "", "")
+	}
+	return newSpan(SpanClient, serviceMethod, client.peer, parent.TraceID, parent.SpanID)
+}
+
+func (client *Client) finishSpan(span *Span, err error) {
+	if span == nil {
+		return
+	}
+	var msg string
+	if err != nil {
+		msg = err.Error()
+	}
+	span.finish(client.exporterOf(), msg)
+}
+
+// exporterValue wraps the exporter of a client, atomic.Value needs a
+// consistent concrete type.
+type exporterValue struct {
+	Exporter
+}
+
+// SetExporter makes the client report a span for every Call and batched
+// call to e, nil stops it. It may be called while calls are in flight.
+func (client *Client) SetExporter(e Exporter) {
+	client.exporter.Store(exporterValue{e})
+}
+
+func (client *Client) exporterOf() Exporter {
+	v, _ := client.exporter.Load().(exporterValue)
+	return v.Exporter
+}
+
+// setTrace propagates span in h, span may be nil.
+func setTrace(h *codec.Header, span *Span) {
+	if span == nil {
+		h.TraceID, h.SpanID = "", ""
+		return
+	}
+	h.TraceID, h.SpanID = span.TraceID, span.SpanID
+}
+
+// FileExporter writes spans to a file as JSON lines, for local analysis.
+type FileExporter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+var _ Exporter = (*FileExporter)(nil)
+
+// NewFileExporter creates an exporter appending to the file at path.
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (e *FileExporter) Export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(span); err != nil {
+		log.Println("rpc trace: export error:", err)
+	}
+}
+
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.f.Close()
+}
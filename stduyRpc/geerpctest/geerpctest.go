@@ -0,0 +1,211 @@
+// Package geerpctest provides fake geerpc servers for testing the code
+// using geerpc: the answer to each ServiceMethod is scripted, including
+// errors, delays and dropped connections, so that retries and timeouts
+// can be tested deterministically.
+package geerpctest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"studyRpc/codec"
+	"studyRpc/geerpc"
+	"studyRpc/xclient"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Handler scripts the answer to a call.
+type Handler struct {
+	Reply interface{}   // sent as the reply, it must decode into the reply of the caller
+	Error string        // if set, the call fails with this error
+	Delay time.Duration // waited before answering
+	Drop  bool          // close the connection instead of answering
+}
+
+// Server is a fake geerpc server listening on an in-process address.
+// The arguments of the calls are discarded, the answers are the ones
+// scripted with Handle.
+type Server struct {
+	Addr string // address of the server for geerpc.XDial
+
+	l        net.Listener
+	mu       sync.Mutex // protect following
+	handlers map[string][]Handler
+	calls    map[string]int
+	conns    map[io.Closer]struct{}
+	wg       sync.WaitGroup
+}
+
+var serverID uint64
+
+// NewServer starts a fake server, closed when the test ends.
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+	name := fmt.Sprintf("geerpctest-%d", atomic.AddUint64(&serverID, 1))
+	l, err := geerpc.ListenInproc(name)
+	if err != nil {
+		tb.Fatal("geerpctest: listen failed:", err)
+	}
+	s := &Server{
+		Addr:     "inproc@" + name,
+		l:        l,
+		handlers: make(map[string][]Handler),
+		calls:    make(map[string]int),
+		conns:    make(map[io.Closer]struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Handle scripts the answers to serviceMethod: the i-th call gets the
+// i-th handler, the last one answers all the following calls.
+// A call of a method without handlers fails.
+func (s *Server) Handle(serviceMethod string, handlers ...Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[serviceMethod] = handlers
+	s.calls[serviceMethod] = 0
+}
+
+// Calls returns the number of calls of serviceMethod received so far.
+func (s *Server) Calls(serviceMethod string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[serviceMethod]
+}
+
+// DropConnections closes the open connections, like a crash of the server
+// would. New connections are still accepted.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+// Close stops the server and closes its connections.
+func (s *Server) Close() {
+	_ = s.l.Close()
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+// next returns the handler of the next call of serviceMethod.
+func (s *Server) next(serviceMethod string) (Handler, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.calls[serviceMethod]
+	s.calls[serviceMethod]++
+	handlers := s.handlers[serviceMethod]
+	if len(handlers) == 0 {
+		return Handler{}, false
+	}
+	if n >= len(handlers) {
+		n = len(handlers) - 1
+	}
+	return handlers[n], true
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn speaks the geerpc protocol: the handshake, then the scripted
+// answers to the requests.
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		_ = conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	var opt geerpc.Option
+	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+		return
+	}
+	newCodec := codec.NewCodecFuncMap[opt.CodecType]
+	ack := geerpc.Ack{Version: geerpc.ProtocolVersion, CodecType: opt.CodecType, Features: []string{geerpc.FeatureOneWay}}
+	if opt.MagicNumber != geerpc.MagicNumber || newCodec == nil {
+		ack.Error = "geerpctest: invalid options"
+	}
+	if err := json.NewEncoder(conn).Encode(&ack); err != nil || ack.Error != "" {
+		return
+	}
+	cc := newCodec(conn)
+	var sending sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		var h codec.Header
+		if err := cc.ReadHeader(&h); err != nil {
+			return
+		}
+		if err := cc.ReadBody(nil); err != nil {
+			return
+		}
+		wg.Add(1)
+		go func(h codec.Header) {
+			defer wg.Done()
+			s.answer(cc, &sending, &h)
+		}(h)
+	}
+}
+
+func (s *Server) answer(cc codec.Codec, sending *sync.Mutex, h *codec.Header) {
+	handler, ok := s.next(h.ServiceMethod)
+	if !ok {
+		handler.Error = "geerpctest: unexpected call of " + h.ServiceMethod
+	}
+	time.Sleep(handler.Delay)
+	if handler.Drop {
+		_ = cc.Close()
+		return
+	}
+	if h.OneWay {
+		return
+	}
+	var reply interface{} = struct{}{}
+	if handler.Error != "" {
+		h.Error = handler.Error
+	} else if handler.Reply != nil {
+		reply = handler.Reply
+	}
+	sending.Lock()
+	defer sending.Unlock()
+	_ = cc.Write(h, reply)
+}
+
+// NewXClient returns an XClient selecting among servers with mode,
+// closed when the test ends.
+func NewXClient(tb testing.TB, mode xclient.SelectMode, opt *geerpc.Option, servers ...*Server) *xclient.XClient {
+	tb.Helper()
+	if len(servers) == 0 {
+		tb.Fatal("geerpctest: no servers")
+	}
+	addrs := make([]string, 0, len(servers))
+	for _, s := range servers {
+		addrs = append(addrs, s.Addr)
+	}
+	xc := xclient.NewXClient(xclient.NewMultiServerDiscovery(addrs), mode, opt)
+	tb.Cleanup(func() { _ = xc.Close() })
+	return xc
+}
@@ -0,0 +1,69 @@
+package geerpctest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"studyRpc/geerpc"
+	"studyRpc/xclient"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(t)
+	s.Handle("Foo.Sum", Handler{Error: "busy"}, Handler{Reply: 3})
+	s.Handle("Foo.Slow", Handler{Reply: 1, Delay: 200 * time.Millisecond})
+
+	client, err := geerpc.XDial(s.Addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", 1, &reply)
+	_assert(err != nil && err.Error() == "busy", "expect the scripted error, got %v", err)
+	err = client.Call(context.Background(), "Foo.Sum", 1, &reply)
+	_assert(err == nil && reply == 3, "expect the scripted reply, got %d %v", reply, err)
+	err = client.Call(context.Background(), "Foo.Sum", 1, &reply)
+	_assert(err == nil && reply == 3, "expect the last handler to repeat, got %d %v", reply, err)
+	_assert(s.Calls("Foo.Sum") == 3, "expect 3 calls, got %d", s.Calls("Foo.Sum"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.Call(ctx, "Foo.Slow", 1, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "deadline"), "expect a timeout, got %v", err)
+	err = client.Call(context.Background(), "Foo.Nope", 1, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "unexpected call"), "expect an unexpected call, got %v", err)
+}
+
+func TestServer_drop(t *testing.T) {
+	s := NewServer(t)
+	s.Handle("Foo.Sum", Handler{Drop: true}, Handler{Reply: 3})
+	client, _ := geerpc.XDial(s.Addr)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", 1, &reply)
+	_assert(err != nil && !client.IsAvailable(), "expect the connection to be dropped, got %v", err)
+}
+
+func TestNewXClient(t *testing.T) {
+	bad, good := NewServer(t), NewServer(t)
+	bad.Handle("Foo.Sum", Handler{Drop: true})
+	good.Handle("Foo.Sum", Handler{Reply: 3})
+	xc := NewXClient(t, xclient.RoundRobinSelect, nil, bad, good)
+
+	var reply int
+	failures := 0
+	for i := 0; i < 4; i++ {
+		if err := xc.Call(context.Background(), "Foo.Sum", 1, &reply); err != nil {
+			failures++
+		}
+	}
+	_assert(failures == 2, "expect every other call to fail, got %d failures", failures)
+	_assert(bad.Calls("Foo.Sum") == 2 && good.Calls("Foo.Sum") == 2, "expect the calls to be spread")
+}
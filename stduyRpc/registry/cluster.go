@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// peerClient is used to talk to the peers, a peer that is down must not
+// block the heartbeats.
+var peerClient = &http.Client{Timeout: 5 * time.Second}
+
+// Replicate makes r a node of a cluster with the registries at peers
+// (their URLs, r excluded). The heartbeats and deregistrations r receives
+// are forwarded to the peers, and every interval r pulls the servers of each peer, keeping
+// the most recent heartbeat of every server, so that a node that was down
+// or missed a forward catches up. Any node can then serve reads and
+// heartbeats. Call Close to stop syncing.
+func (r *GeeRegistry) Replicate(peers []string, interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers = append([]string(nil), peers...)
+	if r.done == nil {
+		r.done = make(chan struct{})
+	}
+	r.wg.Add(1)
+	go r.syncLoop(interval, r.done)
+}
+
+func (r *GeeRegistry) syncLoop(interval time.Duration, done chan struct{}) {
+	defer r.wg.Done()
+	r.syncPeers()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			r.syncPeers()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (r *GeeRegistry) getPeers() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.peers
+}
+
+// syncPeers merges the servers of all the peers into r.
+func (r *GeeRegistry) syncPeers() {
+	for _, peer := range r.getPeers() {
+		items, err := fetchSnapshot(peer)
+		if err != nil {
+			log.Println("rpc registry: sync with", peer, "failed:", err)
+			continue
+		}
+		r.merge(items)
+	}
+}
+
+func fetchSnapshot(peer string) ([]snapshotItem, error) {
+	resp, err := peerClient.Get(peer + "?sync=1")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("rpc registry: " + resp.Status)
+	}
+	var items []snapshotItem
+	err = json.NewDecoder(resp.Body).Decode(&items)
+	return items, err
+}
+
+// forward sends a heartbeat (POST) or a deregistration (DELETE) received
+// from a server to the peers, in the background. A failure is repaired by
+// the next sync.
+func (r *GeeRegistry) forward(method string, item ServerItem) {
+	for _, peer := range r.getPeers() {
+		go func(peer string) {
+			if err := sendItem(peerClient, method, peer, item, true); err != nil {
+				log.Println("rpc registry: forward to", peer, "failed:", err)
+			}
+		}(peer)
+	}
+}
@@ -0,0 +1,112 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"studyRpc/geerpc"
+	"sync"
+	"time"
+)
+
+// HealthCheck makes r probe every registered server each interval: it
+// dials the server, performs the geerpc handshake and calls
+// geerpc.HealthMethod, all within timeout. A server whose methods hold
+// every slot of geerpc.Server.MaxConcurrent answers geerpc.HealthBusy and
+// fails the probe, without MaxConcurrent a wedged server can't be told
+// from a healthy one. A server failing threshold
+// consecutive probes is unhealthy, it is left out of the alive servers
+// until a probe succeeds again, while its heartbeats still keep it
+// registered. Call Close to stop probing.
+func (r *GeeRegistry) HealthCheck(interval, timeout time.Duration, threshold int) {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.threshold = threshold
+	if r.done == nil {
+		r.done = make(chan struct{})
+	}
+	r.wg.Add(1)
+	go r.healthLoop(interval, timeout, r.done)
+}
+
+func (r *GeeRegistry) healthLoop(interval, timeout time.Duration, done chan struct{}) {
+	defer r.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			r.probeAll(timeout)
+		case <-done:
+			return
+		}
+	}
+}
+
+// probeAll probes the servers concurrently and records the results.
+func (r *GeeRegistry) probeAll(timeout time.Duration) {
+	r.mu.Lock()
+	addrs := make([]string, 0, len(r.servers))
+	for addr := range r.servers {
+		addrs = append(addrs, addr)
+	}
+	r.mu.Unlock()
+
+	results := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			results[i] = probe(addr, timeout)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failures := make(map[string]int, len(addrs))
+	for i, addr := range addrs {
+		if _, ok := r.servers[addr]; !ok {
+			continue // removed while probing
+		}
+		wasHealthy := r.healthy(addr)
+		if results[i] != nil {
+			failures[addr] = r.failures[addr] + 1
+		}
+		r.failures[addr] = failures[addr]
+		if r.healthy(addr) != wasHealthy {
+			r.bump()
+		}
+	}
+	r.failures = failures // forget the servers that are gone
+}
+
+// healthy reports whether the server at addr passes the health check,
+// r.mu must be held.
+func (r *GeeRegistry) healthy(addr string) bool {
+	return r.threshold == 0 || r.failures[addr] < r.threshold
+}
+
+// probe checks the server at addr, given in the protocol@addr form of
+// geerpc.XDial.
+func probe(addr string, timeout time.Duration) error {
+	client, err := geerpc.XDial(addr, &geerpc.Option{ConnectTimeout: timeout})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var status string
+	// leave half of the timeout to wait for a slot of a saturated server
+	if err := client.Call(ctx, geerpc.HealthMethod, (timeout / 2).String(), &status); err != nil {
+		return err
+	}
+	if status != geerpc.HealthServing {
+		return errors.New("rpc registry: " + addr + " is " + status)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package registry
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotItem is the persisted form of a ServerItem.
+type snapshotItem struct {
+	ServerItem
+	Start   time.Time `json:"start"`             // last heartbeat, or deregistration time if Removed
+	Removed bool      `json:"removed,omitempty"` // a tombstone, see removeServer
+}
+
+// Save writes a snapshot of the servers to path. The file is replaced
+// atomically, so a crash never leaves a truncated snapshot behind.
+func (r *GeeRegistry) Save(path string) error {
+	data, err := json.MarshalIndent(r.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load restores the servers saved by Save. Entries whose last heartbeat
+// is older than the timeout are dropped, a server already known keeps
+// the item of its most recent heartbeat.
+func (r *GeeRegistry) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var items []snapshotItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	r.merge(items)
+	return nil
+}
+
+func (r *GeeRegistry) snapshot() []snapshotItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	items := make([]snapshotItem, 0, len(r.servers))
+	for _, s := range r.servers {
+		items = append(items, snapshotItem{ServerItem: *s, Start: s.start})
+	}
+	for addr, at := range r.removed {
+		items = append(items, snapshotItem{ServerItem: ServerItem{Addr: addr}, Start: at, Removed: true})
+	}
+	return items
+}
+
+// merge adds the items whose heartbeat is more recent than the one known,
+// and applies the tombstones. Expired items are ignored.
+func (r *GeeRegistry) merge(items []snapshotItem) {
+	now := time.Now()
+	for _, item := range items {
+		if item.Removed {
+			if item.Start.Add(r.tombstoneTTL()).After(now) {
+				r.removeServer(item.Addr, item.Start)
+			}
+			continue
+		}
+		r.mergeItem(item, now)
+	}
+}
+
+func (r *GeeRegistry) mergeItem(item snapshotItem, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timeout != 0 && !item.Start.Add(r.timeout).After(now) {
+		return // expired while the registry was down
+	}
+	if at, ok := r.removed[item.Addr]; ok && !item.Start.After(at) {
+		return // deregistered since
+	}
+	if s := r.servers[item.Addr]; s == nil || item.Start.After(s.start) {
+		s := item.ServerItem
+		s.start = item.Start
+		if old := r.servers[item.Addr]; old == nil || !sameItem(old, &s) {
+			r.bump()
+		}
+		r.servers[item.Addr] = &s
+		delete(r.removed, item.Addr)
+	}
+}
+
+// Persist restores the snapshot at path, if any, then saves the servers
+// to it every interval, and a last time when Close is called. Calling it
+// again for the same path until Close does nothing.
+func (r *GeeRegistry) Persist(path string, interval time.Duration) error {
+	r.mu.Lock()
+	persisting := r.persisting[path]
+	r.mu.Unlock()
+	if persisting {
+		return nil
+	}
+	if err := r.Load(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.persisting[path] {
+		return nil // raced with another Persist
+	}
+	if r.persisting == nil {
+		r.persisting = make(map[string]bool)
+	}
+	r.persisting[path] = true
+	if r.done == nil {
+		r.done = make(chan struct{})
+	}
+	r.wg.Add(1)
+	go r.persist(path, interval, r.done)
+	return nil
+}
+
+func (r *GeeRegistry) persist(path string, interval time.Duration, done chan struct{}) {
+	defer r.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for stop := false; !stop; {
+		select {
+		case <-t.C:
+		case <-done:
+			stop = true
+		}
+		if err := r.Save(path); err != nil {
+			log.Println("rpc registry: snapshot error:", err)
+		}
+	}
+}
+
+// Close stops the background work started by Persist, Replicate and
+// HealthCheck and waits for it to finish.
+func (r *GeeRegistry) Close() error {
+	r.mu.Lock()
+	if r.done != nil {
+		close(r.done)
+		r.done = nil
+	}
+	r.persisting = nil
+	r.mu.Unlock()
+	r.wg.Wait()
+	return nil
+}
@@ -1,20 +1,64 @@
 package registry
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// GeeRegistry is a simple register center, provide following functions.
+// add a server and receive heartbeat to keep it alive.
+// returns all alive servers, or the servers of a service, and delete dead servers sync simultaneously.
 type GeeRegistry struct {
 	timeout time.Duration
 	mu      sync.Mutex // protect following
 	servers map[string]*ServerItem
+	removed map[string]time.Time // tombstones of the deregistered servers, see removeServer
+	version uint64               // bumped whenever the alive servers change
+	changed chan struct{}        // closed and replaced on every change, wakes up the watchers
+	peers   []string             // other registries of the cluster, see Replicate
+	done    chan struct{}        // closed by Close to stop the background work
+	wg      sync.WaitGroup       // background goroutines, see Close
+
+	persisting map[string]bool // snapshot files saved in the background, see Persist
+
+	failures  map[string]int // consecutive failed probes, see HealthCheck
+	threshold int            // failed probes making a server unhealthy, 0 if not checked
 }
 
+// ServerItem describes a registered server. Everything but Addr is
+// optional metadata, reported by the server in its heartbeats.
 type ServerItem struct {
-	Addr  string
-	start time.Time
+	Addr     string            `json:"addr"`
+	Services []string          `json:"services,omitempty"` // names of the services hosted, none means any
+	Version  string            `json:"version,omitempty"`
+	Weight   int               `json:"weight,omitempty"` // relative share of the load, 0 counts as 1
+	Zone     string            `json:"zone,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	start    time.Time
+}
+
+// Hosts reports whether the server hosts service. A server that
+// doesn't list its services is assumed to host all of them.
+func (s *ServerItem) Hosts(service string) bool {
+	if service == "" || len(s.Services) == 0 {
+		return true
+	}
+	for _, name := range s.Services {
+		if name == service {
+			return true
+		}
+	}
+	return false
 }
 
 const (
@@ -22,39 +66,341 @@ const (
 	defaultTimeout = time.Minute * 5
 )
 
+// HTTP headers of the registry protocol, the body carries the metadata as JSON.
+const (
+	HeaderServers = "X-Geerpc-Servers" // alive servers, comma separated
+	HeaderServer  = "X-Geerpc-Server"  // address of the server sending a heartbeat
+	HeaderVersion = "X-Geerpc-Version" // version of the alive servers, see ServeHTTP
+	HeaderReplica = "X-Geerpc-Replica" // set on heartbeats forwarded by a peer registry
+)
+
+const (
+	defaultWatchWait = time.Second * 30
+	maxWatchWait     = time.Minute * 2
+)
+
 // New create a registry instance with timeout setting
 func New(timeout time.Duration) *GeeRegistry {
 	return &GeeRegistry{
 		servers: make(map[string]*ServerItem),
+		removed: make(map[string]time.Time),
+		changed: make(chan struct{}),
 		timeout: timeout,
+
+		failures: make(map[string]int),
 	}
 }
 
 var DefaultGeeRegister = New(defaultTimeout)
 
-//添加服务实例，如果服务已经存在，则更新 start
-func (r *GeeRegistry) putServer(addr string) {
+// 添加服务实例，如果服务已经存在，则更新 start 和元数据
+func (r *GeeRegistry) putServer(item ServerItem) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	s := r.servers[addr]
-	if s == nil {
-		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
-	} else {
-		s.start = time.Now() // if exists, update start time to keep alive
+	item.start = time.Now()
+	if old := r.servers[item.Addr]; old == nil || !sameItem(old, &item) {
+		r.bump()
 	}
+	r.servers[item.Addr] = &item
+	delete(r.removed, item.Addr) // registered again
 }
 
-func (r *GeeRegistry) aliveServers() []string {
+// removeServer deregisters the server at addr. A tombstone is kept until
+// the server would have expired, so that a peer which still knows the
+// server doesn't bring it back when syncing.
+func (r *GeeRegistry) removeServer(addr string, at time.Time) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	var alive []string
+	if s := r.servers[addr]; s != nil && !s.start.After(at) {
+		delete(r.servers, addr)
+		r.bump()
+	}
+	if t, ok := r.removed[addr]; !ok || at.After(t) {
+		r.removed[addr] = at
+	}
+}
+
+// tombstoneTTL is how long the tombstone of a deregistered server is kept.
+func (r *GeeRegistry) tombstoneTTL() time.Duration {
+	if r.timeout == 0 {
+		return defaultTimeout
+	}
+	return r.timeout
+}
+
+// sameItem reports whether a and b only differ by their last heartbeat.
+func sameItem(a, b *ServerItem) bool {
+	x, y := *a, *b
+	x.start, y.start = time.Time{}, time.Time{}
+	return reflect.DeepEqual(x, y)
+}
+
+// bump records a change of the alive servers, r.mu must be held.
+func (r *GeeRegistry) bump() {
+	r.version++
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// expire deletes the servers whose heartbeat is too old, r.mu must be held.
+// It returns when the next server expires, zero if none will.
+func (r *GeeRegistry) expire() (next time.Time) {
+	now := time.Now()
+	for addr, at := range r.removed {
+		if !at.Add(r.tombstoneTTL()).After(now) {
+			delete(r.removed, addr)
+		}
+	}
+	if r.timeout == 0 {
+		return
+	}
 	for addr, s := range r.servers {
-		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
-			alive = append(alive, addr)
-		} else {
+		deadline := s.start.Add(r.timeout)
+		if !deadline.After(now) {
 			delete(r.servers, addr)
+			r.bump()
+		} else if next.IsZero() || deadline.Before(next) {
+			next = deadline
 		}
 	}
-	sort.Strings(alive)
+	return
+}
+
+func (r *GeeRegistry) aliveServers() []string {
+	items, _ := r.aliveItems("")
+	alive := make([]string, 0, len(items))
+	for _, item := range items {
+		alive = append(alive, item.Addr)
+	}
 	return alive
 }
+
+// aliveItems returns the alive and healthy servers hosting service sorted
+// by address, all of them if service is empty, and their version.
+func (r *GeeRegistry) aliveItems(service string) ([]ServerItem, uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+	var alive []ServerItem
+	for _, s := range r.servers {
+		if s.Hosts(service) && r.healthy(s.Addr) {
+			alive = append(alive, *s)
+		}
+	}
+	sort.Slice(alive, func(i, j int) bool { return alive[i].Addr < alive[j].Addr })
+	return alive, r.version
+}
+
+// watch blocks until the version of the alive servers differs from
+// version, wait elapses or ctx is done.
+func (r *GeeRegistry) watch(ctx context.Context, version uint64, wait time.Duration) {
+	deadline := time.Now().Add(wait)
+	for {
+		r.mu.Lock()
+		next := r.expire()
+		if r.version != version {
+			r.mu.Unlock()
+			return
+		}
+		changed := r.changed
+		r.mu.Unlock()
+
+		d := time.Until(deadline)
+		if d <= 0 {
+			return
+		}
+		if !next.IsZero() && time.Until(next) < d {
+			d = time.Until(next) // wake up to expire the server
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-changed:
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		t.Stop()
+	}
+}
+
+// Runs at /_geerpc_/registry
+//   - GET returns the alive servers, of the service given by ?service= if any,
+//     and their version in the X-Geerpc-Version header
+//   - GET with ?version= is a long poll, it waits until the version differs
+//     or ?wait= (30s by default) elapses before answering
+//   - GET with ?sync=1 returns all the servers with their last heartbeat,
+//     for the peers of a cluster
+//   - POST is a heartbeat, the ServerItem in the body is optional
+//   - DELETE deregisters the server given by the X-Geerpc-Server header
+func (r *GeeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		query := req.URL.Query()
+		if query.Get("sync") != "" {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(r.snapshot())
+			return
+		}
+		if v := query.Get("version"); v != "" {
+			version, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, "rpc registry: invalid version "+v, http.StatusBadRequest)
+				return
+			}
+			wait := defaultWatchWait
+			if v := query.Get("wait"); v != "" {
+				if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+					http.Error(w, "rpc registry: invalid wait "+v, http.StatusBadRequest)
+					return
+				}
+			}
+			if wait > maxWatchWait {
+				wait = maxWatchWait
+			}
+			r.watch(req.Context(), version, wait)
+		}
+		items, version := r.aliveItems(query.Get("service"))
+		addrs := make([]string, 0, len(items))
+		for _, item := range items {
+			addrs = append(addrs, item.Addr)
+		}
+		// keep it simple, server is in req.Header
+		w.Header().Set(HeaderServers, strings.Join(addrs, ","))
+		w.Header().Set(HeaderVersion, strconv.FormatUint(version, 10))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(items)
+	case http.MethodPost:
+		var item ServerItem
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(req.Body).Decode(&item); err != nil {
+				http.Error(w, "rpc registry: invalid server item: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if addr := req.Header.Get(HeaderServer); addr != "" {
+			item.Addr = addr
+		}
+		if item.Addr == "" {
+			http.Error(w, "rpc registry: missing server address", http.StatusBadRequest)
+			return
+		}
+		r.putServer(item)
+		if req.Header.Get(HeaderReplica) == "" {
+			r.forward(http.MethodPost, item)
+		}
+	case http.MethodDelete:
+		addr := req.Header.Get(HeaderServer)
+		if addr == "" {
+			http.Error(w, "rpc registry: missing server address", http.StatusBadRequest)
+			return
+		}
+		r.removeServer(addr, time.Now())
+		if req.Header.Get(HeaderReplica) == "" {
+			r.forward(http.MethodDelete, ServerItem{Addr: addr})
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleHTTP registers an HTTP handler for GeeRegistry messages on registryPath
+func (r *GeeRegistry) HandleHTTP(registryPath string) {
+	http.Handle(registryPath, r)
+	log.Println("rpc registry path:", registryPath)
+}
+
+func HandleHTTP() {
+	DefaultGeeRegister.HandleHTTP(defaultPath)
+}
+
+// Heartbeat send a heartbeat message every once in a while
+// it's a helper function for a server to register or send heartbeat.
+// registry may list several registries of a cluster separated by commas,
+// the first one that answers gets the heartbeat.
+// A failed heartbeat is logged and the next one is sent on schedule.
+// The returned function stops the heartbeats and deregisters the server,
+// call it before shutting the server down so that clients stop using it
+// at once.
+func Heartbeat(registry string, item ServerItem, duration time.Duration) (deregister func() error) {
+	if duration == 0 {
+		// make sure there is enough time to send heart beat
+		// before it's removed from registry
+		duration = defaultTimeout - time.Duration(1)*time.Minute
+	}
+	_ = sendHeartbeat(registry, item)
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(duration)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				// errors are logged, the registry may be back for the next one
+				_ = sendHeartbeat(registry, item)
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() error {
+		once.Do(func() { close(done) })
+		<-stopped // a heartbeat in flight would register the server again
+		return Deregister(registry, item.Addr)
+	}
+}
+
+// Deregister removes the server at addr from the registry, which may list
+// several registries of a cluster like in Heartbeat. Watching discoveries
+// drop the server at once, polling ones on their next refresh.
+func Deregister(registry, addr string) error {
+	var err error
+	for _, reg := range strings.Split(registry, ",") {
+		if err = sendItem(http.DefaultClient, http.MethodDelete, reg, ServerItem{Addr: addr}, false); err == nil {
+			return nil
+		}
+	}
+	log.Println("rpc server: deregister err:", err)
+	return err
+}
+
+func sendHeartbeat(registry string, item ServerItem) error {
+	log.Println(item.Addr, "send heart beat to registry", registry)
+	var err error
+	for _, addr := range strings.Split(registry, ",") {
+		if err = sendItem(http.DefaultClient, http.MethodPost, addr, item, false); err == nil {
+			return nil
+		}
+	}
+	log.Println("rpc server: heart beat err:", err)
+	return err
+}
+
+// sendItem sends item to the registry at addr, method is POST for a
+// heartbeat and DELETE to deregister the server.
+func sendItem(client *http.Client, method, addr string, item ServerItem, replica bool) error {
+	body, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, addr, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HeaderServer, item.Addr)
+	req.Header.Set("Content-Type", "application/json")
+	if replica {
+		req.Header.Set(HeaderReplica, "1")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New("rpc registry: " + addr + ": " + resp.Status)
+	}
+	return nil
+}
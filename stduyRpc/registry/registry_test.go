@@ -0,0 +1,225 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"studyRpc/geerpc"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+func TestGeeRegistry_snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r := New(time.Minute)
+	r.putServer(ServerItem{Addr: "tcp@:1", Services: []string{"Foo"}, Weight: 2})
+	r.putServer(ServerItem{Addr: "tcp@:2"})
+	r.servers["tcp@:2"].start = time.Now().Add(-2 * time.Minute) // expired
+	_assert(r.Save(path) == nil, "save failed")
+
+	restored := New(time.Minute)
+	_assert(restored.Load(path) == nil, "load failed")
+	alive := restored.aliveServers()
+	_assert(reflect.DeepEqual(alive, []string{"tcp@:1"}), "expect only the fresh server to be restored, got %v", alive)
+	s := restored.servers["tcp@:1"]
+	_assert(s.start.Equal(r.servers["tcp@:1"].start), "expect the last heartbeat to be restored")
+	_assert(s.Weight == 2 && reflect.DeepEqual(s.Services, []string{"Foo"}), "expect the metadata to be restored, got %+v", s)
+}
+
+func TestGeeRegistry_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r := New(time.Minute)
+	_assert(r.Persist(path, time.Hour) == nil, "a missing snapshot isn't an error")
+	r.putServer(ServerItem{Addr: "tcp@:1"})
+	other := New(time.Minute)
+	other.putServer(ServerItem{Addr: "tcp@:2"})
+	_ = other.Save(path)
+	_assert(r.Persist(path, time.Hour) == nil, "persist failed")
+	alive := r.aliveServers()
+	_assert(reflect.DeepEqual(alive, []string{"tcp@:1"}), "expect a second Persist to do nothing, got %v", alive)
+	_ = r.Close() // saves a last snapshot
+	_assert(r.persisting == nil, "expect Close to stop persisting")
+
+	restored := New(time.Minute)
+	_assert(restored.Persist(path, time.Hour) == nil, "persist failed")
+	defer func() { _ = restored.Close() }()
+	alive = restored.aliveServers()
+	_assert(reflect.DeepEqual(alive, []string{"tcp@:1"}), "expect the snapshot to be reloaded, got %v", alive)
+}
+
+func TestGeeRegistry_ServeHTTP(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	Heartbeat(ts.URL, ServerItem{Addr: "tcp@:1", Services: []string{"Foo", "Bar"}, Zone: "a"}, time.Hour)
+	Heartbeat(ts.URL, ServerItem{Addr: "tcp@:2", Services: []string{"Bar"}, Weight: 3}, time.Hour)
+
+	get := func(query string) (string, []ServerItem) {
+		resp, err := http.Get(ts.URL + query)
+		_assert(err == nil, "get failed: %v", err)
+		defer func() { _ = resp.Body.Close() }()
+		var items []ServerItem
+		_assert(json.NewDecoder(resp.Body).Decode(&items) == nil, "invalid body")
+		return resp.Header.Get(HeaderServers), items
+	}
+	servers, items := get("")
+	_assert(servers == "tcp@:1,tcp@:2" && len(items) == 2, "expect all servers, got %q", servers)
+	servers, items = get("?service=Foo")
+	_assert(servers == "tcp@:1" && items[0].Zone == "a", "expect the servers of Foo, got %q %+v", servers, items)
+	servers, items = get("?service=Bar")
+	_assert(servers == "tcp@:1,tcp@:2" && items[1].Weight == 3, "expect the servers of Bar, got %q %+v", servers, items)
+	servers, _ = get("?service=Baz")
+	_assert(servers == "", "expect no server of Baz, got %q", servers)
+
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"zone":"a"}`))
+	_assert(err == nil && resp.StatusCode == http.StatusBadRequest, "expect a missing address to be a bad request, got %v", err)
+	_ = resp.Body.Close()
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL, nil)
+	resp, err = http.DefaultClient.Do(req)
+	_assert(err == nil && resp.StatusCode == http.StatusBadRequest, "expect a missing address to be a bad request, got %v", err)
+	_ = resp.Body.Close()
+}
+
+func TestGeeRegistry_watch(t *testing.T) {
+	r := New(time.Minute)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	watch := func(query string) (string, string) {
+		resp, err := http.Get(ts.URL + query)
+		_assert(err == nil, "get failed: %v", err)
+		_ = resp.Body.Close()
+		return resp.Header.Get(HeaderServers), resp.Header.Get(HeaderVersion)
+	}
+	_, version := watch("")
+	_assert(version == "0", "expect version 0, got %s", version)
+
+	start := time.Now()
+	_, version = watch("?version=0&wait=50ms")
+	_assert(version == "0" && time.Since(start) >= 50*time.Millisecond, "expect the watch to time out")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.putServer(ServerItem{Addr: "tcp@:1"})
+		r.putServer(ServerItem{Addr: "tcp@:1"}) // a heartbeat isn't a change
+	}()
+	servers, version := watch("?version=0&wait=10s")
+	_assert(servers == "tcp@:1" && version == "1", "expect the watch to return the new server, got %q %s", servers, version)
+
+	// expired servers wake up the watchers
+	r.mu.Lock()
+	r.timeout = 100 * time.Millisecond
+	r.mu.Unlock()
+	servers, version = watch("?version=1&wait=10s")
+	_assert(servers == "" && version == "2", "expect the server to expire, got %q %s", servers, version)
+}
+
+func TestGeeRegistry_Replicate(t *testing.T) {
+	r1, r2 := New(time.Minute), New(time.Minute)
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts1.Close()
+	defer ts2.Close()
+	r2.putServer(ServerItem{Addr: "tcp@:1"}) // known before the cluster is formed
+	r1.Replicate([]string{ts2.URL}, time.Hour)
+	r2.Replicate([]string{ts1.URL}, time.Hour)
+	defer func() { _ = r1.Close() }()
+	defer func() { _ = r2.Close() }()
+
+	// the first registry is down, the heartbeat fails over to the second
+	Heartbeat("http://127.0.0.1:1,"+ts2.URL, ServerItem{Addr: "tcp@:2"}, time.Hour)
+	deadline := time.Now().Add(time.Second)
+	for {
+		alive := r1.aliveServers()
+		if reflect.DeepEqual(alive, []string{"tcp@:1", "tcp@:2"}) {
+			break
+		}
+		_assert(time.Now().Before(deadline), "expect the servers to be replicated, got %v", alive)
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(r2.servers["tcp@:1"].start.Equal(r1.servers["tcp@:1"].start), "expect the last heartbeat to be kept")
+}
+
+func TestHeartbeat_retry(t *testing.T) {
+	r := New(time.Minute)
+	var down int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+	deregister := Heartbeat(ts.URL, ServerItem{Addr: "tcp@:1"}, 10*time.Millisecond)
+	defer func() { _ = deregister() }()
+	time.Sleep(30 * time.Millisecond) // a few failed heartbeats
+	atomic.StoreInt32(&down, 0)
+	deadline := time.Now().Add(time.Second)
+	for len(r.aliveServers()) == 0 {
+		_assert(time.Now().Before(deadline), "expect the heartbeats to go on after failures")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGeeRegistry_Deregister(t *testing.T) {
+	r1, r2 := New(time.Minute), New(time.Minute)
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts1.Close()
+	defer ts2.Close()
+	deregister := Heartbeat(ts1.URL, ServerItem{Addr: "tcp@:1"}, 10*time.Millisecond)
+	r2.merge(r1.snapshot()) // r2 is a peer that will miss the deregistration
+
+	_assert(deregister() == nil, "deregister failed")
+	time.Sleep(50 * time.Millisecond) // no heartbeat registers the server again
+	alive := r1.aliveServers()
+	_assert(len(alive) == 0, "expect the server to be removed, got %v", alive)
+
+	// the tombstone wins over the stale copy of the peer, and is synced to it
+	r1.merge(r2.snapshot())
+	alive = r1.aliveServers()
+	_assert(len(alive) == 0, "expect the server to stay removed, got %v", alive)
+	r2.merge(r1.snapshot())
+	alive = r2.aliveServers()
+	_assert(len(alive) == 0, "expect the tombstone to be synced, got %v", alive)
+
+	// the server can come back
+	r1.putServer(ServerItem{Addr: "tcp@:1"})
+	alive = r1.aliveServers()
+	_assert(reflect.DeepEqual(alive, []string{"tcp@:1"}), "expect the server to be registered again, got %v", alive)
+}
+
+func TestGeeRegistry_HealthCheck(t *testing.T) {
+	l, _ := geerpc.ListenInproc("registry-health")
+	defer func() { _ = l.Close() }()
+	go geerpc.NewServer().Accept(l)
+
+	r := New(time.Minute)
+	r.putServer(ServerItem{Addr: "inproc@registry-health"})
+	r.putServer(ServerItem{Addr: "inproc@registry-wedged"})
+	r.HealthCheck(10*time.Millisecond, 100*time.Millisecond, 2)
+	defer func() { _ = r.Close() }()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		alive := r.aliveServers()
+		if reflect.DeepEqual(alive, []string{"inproc@registry-health"}) {
+			break
+		}
+		_assert(time.Now().Before(deadline), "expect the wedged server to be left out, got %v", alive)
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.mu.Lock()
+	_, registered := r.servers["inproc@registry-wedged"]
+	r.mu.Unlock()
+	_assert(registered, "an unhealthy server stays registered")
+}
@@ -11,8 +11,9 @@ import (
 type SelectMode int
 
 const (
-	RandomSelect     SelectMode = iota // select randomly
-	RoundRobinSelect                   // select using Robbin algorithm
+	RandomSelect         SelectMode = iota // select randomly
+	RoundRobinSelect                       // select using Robbin algorithm
+	WeightedRandomSelect                   // select randomly in proportion to the weights, see GeeRegistryDiscovery
 )
 
 type Discovery interface {
@@ -76,6 +77,29 @@ func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
 	}
 }
 
+// getWeighted selects a server randomly in proportion to its weight.
+func (d *MultiServersDiscovery) getWeighted(weight func(addr string) int) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	total := 0
+	weights := make([]int, len(d.servers))
+	for i, addr := range d.servers {
+		weights[i] = weight(addr)
+		total += weights[i]
+	}
+	if total == 0 {
+		return "", errors.New("rpc discovery: no available servers")
+	}
+	n := d.r.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return d.servers[i], nil
+		}
+		n -= w
+	}
+	return d.servers[len(d.servers)-1], nil
+}
+
 // returns all servers in discovery
 func (d *MultiServersDiscovery) GetAll() ([]string, error) {
 	d.mu.RLock()
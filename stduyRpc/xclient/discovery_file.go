@@ -0,0 +1,208 @@
+package xclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"studyRpc/registry"
+	"sync"
+	"time"
+)
+
+// FileDiscovery reads the servers from a file, reloaded when it changes.
+// The file is either plain text, one "protocol@addr [weight]" per line,
+// blank lines and lines starting with # being ignored:
+//
+//	tcp@10.0.0.1:9999 2
+//	tcp@10.0.0.2:9999
+//
+// or a JSON array whose elements are addresses or objects with the
+// fields of registry.ServerItem:
+//
+//	["tcp@10.0.0.1:9999", {"addr": "tcp@10.0.0.2:9999", "weight": 2}]
+//
+// Weights are used by WeightedRandomSelect, 0 counts as 1.
+type FileDiscovery struct {
+	*MultiServersDiscovery
+	path    string
+	fileMu  sync.Mutex // protect following
+	modTime time.Time  // of the file loaded, with its size
+	size    int64
+	weights map[string]int
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+var _ Discovery = (*FileDiscovery)(nil)
+var _ io.Closer = (*FileDiscovery)(nil)
+
+// NewFileDiscovery loads the servers listed in the file at path, then
+// checks its modification time every interval to reload it. Call Close
+// to stop watching the file.
+func NewFileDiscovery(path string, interval time.Duration) (*FileDiscovery, error) {
+	d := &FileDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(make([]string, 0)),
+		path:                  path,
+		done:                  make(chan struct{}),
+		stopped:               make(chan struct{}),
+	}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	go d.watch(interval)
+	return d, nil
+}
+
+func (d *FileDiscovery) watch(interval time.Duration) {
+	defer close(d.stopped)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := d.Refresh(); err != nil {
+				// keep the servers loaded last, the file may be being written
+				log.Println("rpc discovery: reload", d.path, "failed:", err)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// Refresh reloads the file if its modification time or size changed since
+// it was loaded.
+func (d *FileDiscovery) Refresh() error {
+	d.fileMu.Lock()
+	defer d.fileMu.Unlock()
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(d.modTime) && fi.Size() == d.size && d.weights != nil {
+		return nil
+	}
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	items, err := parseServers(data)
+	if err != nil {
+		return fmt.Errorf("rpc discovery: %s: %v", d.path, err)
+	}
+	d.setItems(items)
+	d.modTime, d.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+// Update the servers of discovery, until the file changes.
+func (d *FileDiscovery) Update(servers []string) error {
+	items := make([]registry.ServerItem, 0, len(servers))
+	for _, addr := range servers {
+		items = append(items, registry.ServerItem{Addr: addr})
+	}
+	d.fileMu.Lock()
+	defer d.fileMu.Unlock()
+	d.setItems(items)
+	return nil
+}
+
+// setItems replaces the servers, d.fileMu must be held.
+func (d *FileDiscovery) setItems(items []registry.ServerItem) {
+	servers := make([]string, 0, len(items))
+	d.weights = make(map[string]int, len(items))
+	for _, item := range items {
+		servers = append(servers, item.Addr)
+		d.weights[item.Addr] = weightOf(item)
+	}
+	_ = d.MultiServersDiscovery.Update(servers)
+}
+
+// Get a server according to mode.
+func (d *FileDiscovery) Get(mode SelectMode) (string, error) {
+	if mode != WeightedRandomSelect {
+		return d.MultiServersDiscovery.Get(mode)
+	}
+	d.fileMu.Lock()
+	defer d.fileMu.Unlock()
+	return d.MultiServersDiscovery.getWeighted(func(addr string) int {
+		return d.weights[addr]
+	})
+}
+
+// Close stops watching the file.
+func (d *FileDiscovery) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
+	<-d.stopped
+	return nil
+}
+
+// parseServers parses a server list in one of the formats of FileDiscovery.
+func parseServers(data []byte) ([]registry.ServerItem, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		return parseServersJSON(data)
+	}
+	var items []registry.ServerItem
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		item := registry.ServerItem{Addr: fields[0]}
+		switch len(fields) {
+		case 1:
+		case 2:
+			w, err := strconv.Atoi(fields[1])
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("line %d: invalid weight %q", i+1, fields[1])
+			}
+			item.Weight = w
+		default:
+			return nil, fmt.Errorf("line %d: expect \"protocol@addr [weight]\"", i+1)
+		}
+		if err := checkAddr(item.Addr); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func parseServersJSON(data []byte) ([]registry.ServerItem, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, err
+	}
+	items := make([]registry.ServerItem, 0, len(raws))
+	for i, raw := range raws {
+		var item registry.ServerItem
+		if raw[0] == '"' {
+			if err := json.Unmarshal(raw, &item.Addr); err != nil {
+				return nil, err
+			}
+		} else if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, fmt.Errorf("server %d: %v", i, err)
+		}
+		if err := checkAddr(item.Addr); err != nil {
+			return nil, fmt.Errorf("server %d: %v", i, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// checkAddr checks that addr has the protocol@addr form of XDial.
+func checkAddr(addr string) error {
+	if parts := strings.SplitN(addr, "@", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid address %q, expect protocol@addr", addr)
+	}
+	return nil
+}
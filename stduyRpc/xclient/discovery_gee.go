@@ -0,0 +1,248 @@
+package xclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"studyRpc/registry"
+	"sync"
+	"time"
+)
+
+// GeeRegistryDiscovery gets the servers of a service from a GeeRegistry.
+// The metadata of the servers is kept, their weights are used by
+// WeightedRandomSelect.
+type GeeRegistryDiscovery struct {
+	*MultiServersDiscovery
+	registries []string // the registries of a cluster, tried in turn
+	current    int      // index of the registry that answered last
+	service    string
+	timeout    time.Duration
+	itemsMu    sync.RWMutex // protect following
+	items      map[string]registry.ServerItem
+	lastUpdate time.Time
+	watching   bool // the watch is up to date, Refresh has nothing to do
+
+	cancel  context.CancelFunc // stops the watch
+	stopped chan struct{}      // closed when the watch returns
+}
+
+const defaultUpdateTimeout = time.Second * 10
+
+// NewGeeRegistryDiscovery creates a discovery of the servers registered
+// at registerAddr hosting service, all servers if service is empty.
+// registerAddr may list the registries of a cluster separated by commas,
+// when one fails the next is used. The list is refreshed when it is
+// older than timeout, so a deregistered server is still returned until
+// then, use NewGeeRegistryWatchDiscovery to drop it at once.
+func NewGeeRegistryDiscovery(registerAddr, service string, timeout time.Duration) *GeeRegistryDiscovery {
+	if timeout == 0 {
+		timeout = defaultUpdateTimeout
+	}
+	return &GeeRegistryDiscovery{
+		MultiServersDiscovery: NewMultiServerDiscovery(make([]string, 0)),
+		registries:            strings.Split(registerAddr, ","),
+		service:               service,
+		timeout:               timeout,
+	}
+}
+
+// NewGeeRegistryWatchDiscovery is like NewGeeRegistryDiscovery, but the
+// servers are pushed by the registry: a long poll of up to wait waits for
+// every change, which is applied as soon as it happens. Call Close to
+// stop watching.
+func NewGeeRegistryWatchDiscovery(registerAddr, service string, wait time.Duration) *GeeRegistryDiscovery {
+	d := NewGeeRegistryDiscovery(registerAddr, service, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel, d.stopped = cancel, make(chan struct{})
+	go d.watch(ctx, wait)
+	return d
+}
+
+var _ Discovery = (*GeeRegistryDiscovery)(nil)
+var _ io.Closer = (*GeeRegistryDiscovery)(nil)
+
+// Update the servers of discovery, servers without metadata get the default one.
+func (d *GeeRegistryDiscovery) Update(servers []string) error {
+	items := make([]registry.ServerItem, 0, len(servers))
+	for _, addr := range servers {
+		items = append(items, registry.ServerItem{Addr: addr})
+	}
+	d.update(items)
+	return nil
+}
+
+func (d *GeeRegistryDiscovery) update(items []registry.ServerItem) {
+	d.itemsMu.Lock()
+	defer d.itemsMu.Unlock()
+	servers := make([]string, 0, len(items))
+	d.items = make(map[string]registry.ServerItem, len(items))
+	for _, item := range items {
+		servers = append(servers, item.Addr)
+		d.items[item.Addr] = item
+	}
+	_ = d.MultiServersDiscovery.Update(servers)
+	d.lastUpdate = time.Now()
+}
+
+// Refresh gets the servers from the registry if they are out of date.
+func (d *GeeRegistryDiscovery) Refresh() error {
+	d.itemsMu.RLock()
+	fresh := d.watching || d.lastUpdate.Add(d.timeout).After(time.Now())
+	d.itemsMu.RUnlock()
+	if fresh {
+		return nil
+	}
+	log.Println("rpc registry: refresh servers from registry")
+	items, _, err := d.fetch(context.Background(), url.Values{})
+	if err != nil {
+		log.Println("rpc registry refresh err:", err)
+		return err
+	}
+	d.update(items)
+	return nil
+}
+
+// fetch gets the servers and their version from the registries, starting
+// with the one that answered last. Versions are counted by each registry,
+// so the other registries are asked for their servers right away instead
+// of being long polled with the version of the last one.
+func (d *GeeRegistryDiscovery) fetch(ctx context.Context, query url.Values) ([]registry.ServerItem, uint64, error) {
+	if d.service != "" {
+		query.Set("service", d.service)
+	}
+	d.itemsMu.RLock()
+	current := d.current
+	d.itemsMu.RUnlock()
+	var err error
+	for i := range d.registries {
+		n := (current + i) % len(d.registries)
+		q := query
+		if n != current && q.Get("version") != "" {
+			q = url.Values{"service": query["service"]}
+		}
+		var items []registry.ServerItem
+		var version uint64
+		if items, version, err = d.fetchFrom(ctx, d.registries[n], q); err == nil {
+			d.itemsMu.Lock()
+			d.current = n
+			d.itemsMu.Unlock()
+			return items, version, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, 0, err
+}
+
+func (d *GeeRegistryDiscovery) fetchFrom(ctx context.Context, addr string, query url.Values) ([]registry.ServerItem, uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.New("rpc registry: " + resp.Status)
+	}
+	var items []registry.ServerItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, 0, err
+	}
+	version, _ := strconv.ParseUint(resp.Header.Get(registry.HeaderVersion), 10, 64)
+	return items, version, nil
+}
+
+// watch long polls the registry until ctx is done. While the registry
+// can't be reached, Refresh falls back to polling.
+func (d *GeeRegistryDiscovery) watch(ctx context.Context, wait time.Duration) {
+	defer close(d.stopped)
+	query := url.Values{}
+	for ctx.Err() == nil {
+		items, version, err := d.fetch(ctx, query)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Println("rpc registry watch err:", err)
+			d.itemsMu.Lock()
+			d.watching = false
+			d.itemsMu.Unlock()
+			query = url.Values{} // fetch the servers again, changes may have been missed
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		d.update(items)
+		d.itemsMu.Lock()
+		d.watching = true
+		d.itemsMu.Unlock()
+		query = url.Values{"version": {strconv.FormatUint(version, 10)}}
+		if wait > 0 {
+			query.Set("wait", wait.String())
+		}
+	}
+}
+
+// Close stops the watch, if any.
+func (d *GeeRegistryDiscovery) Close() error {
+	if d.cancel != nil {
+		d.cancel()
+		<-d.stopped
+	}
+	return nil
+}
+
+// Get a server according to mode, refreshing the servers first if needed.
+func (d *GeeRegistryDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	if mode == WeightedRandomSelect {
+		return d.getWeighted()
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+func (d *GeeRegistryDiscovery) getWeighted() (string, error) {
+	d.itemsMu.RLock()
+	defer d.itemsMu.RUnlock()
+	return d.MultiServersDiscovery.getWeighted(func(addr string) int {
+		return weightOf(d.items[addr])
+	})
+}
+
+func weightOf(item registry.ServerItem) int {
+	if item.Weight <= 0 {
+		return 1
+	}
+	return item.Weight
+}
+
+// GetAll returns all servers, refreshing them first if needed.
+func (d *GeeRegistryDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+// Item returns the metadata of the server at addr.
+func (d *GeeRegistryDiscovery) Item(addr string) (registry.ServerItem, bool) {
+	d.itemsMu.RLock()
+	defer d.itemsMu.RUnlock()
+	item, ok := d.items[addr]
+	return item, ok
+}
@@ -0,0 +1,135 @@
+package xclient
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"studyRpc/registry"
+	"testing"
+	"time"
+)
+
+func TestGeeRegistryDiscovery(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	registry.Heartbeat(ts.URL, registry.ServerItem{Addr: "tcp@:1", Services: []string{"Foo"}, Zone: "a"}, time.Hour)
+	registry.Heartbeat(ts.URL, registry.ServerItem{Addr: "tcp@:2", Services: []string{"Foo", "Bar"}, Weight: 5}, time.Hour)
+
+	d := NewGeeRegistryDiscovery(ts.URL, "Bar", 0)
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 1 && servers[0] == "tcp@:2", "expect the servers of Bar, got %v %v", servers, err)
+	item, ok := d.Item("tcp@:2")
+	_assert(ok && item.Weight == 5, "expect the metadata of tcp@:2, got %+v", item)
+
+	d = NewGeeRegistryDiscovery(ts.URL, "Foo", 0)
+	for i := 0; i < 10; i++ {
+		addr, err := d.Get(WeightedRandomSelect)
+		_assert(err == nil && (addr == "tcp@:1" || addr == "tcp@:2"), "unexpected server %q %v", addr, err)
+	}
+	item, _ = d.Item("tcp@:1")
+	_assert(item.Zone == "a", "expect the metadata of tcp@:1, got %+v", item)
+}
+
+func TestGeeRegistryWatchDiscovery(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	d := NewGeeRegistryWatchDiscovery(ts.URL, "Foo", time.Second)
+	defer func() { _ = d.Close() }()
+
+	registry.Heartbeat(ts.URL, registry.ServerItem{Addr: "tcp@:1", Services: []string{"Foo"}}, time.Hour)
+	registry.Heartbeat(ts.URL, registry.ServerItem{Addr: "tcp@:2", Services: []string{"Bar"}}, time.Hour)
+	deadline := time.Now().Add(time.Second)
+	for {
+		servers, _ := d.MultiServersDiscovery.GetAll()
+		if len(servers) == 1 && servers[0] == "tcp@:1" {
+			break
+		}
+		_assert(time.Now().Before(deadline), "expect the new server to be pushed, got %v", servers)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGeeRegistryDiscovery_failover(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	registry.Heartbeat(ts.URL, registry.ServerItem{Addr: "tcp@:1"}, time.Hour)
+
+	d := NewGeeRegistryDiscovery("http://127.0.0.1:1,"+ts.URL, "", 0)
+	servers, err := d.GetAll()
+	_assert(err == nil && len(servers) == 1, "expect the second registry to answer, got %v %v", servers, err)
+	_assert(d.current == 1, "expect the second registry to be used first next time")
+}
+
+func TestGeeRegistryWatchDiscovery_failover(t *testing.T) {
+	r1, r2 := registry.New(time.Minute), registry.New(time.Minute)
+	ts1, ts2 := httptest.NewServer(r1), httptest.NewServer(r2)
+	defer ts2.Close()
+	// both registries are at version 1, with different servers
+	registry.Heartbeat(ts1.URL, registry.ServerItem{Addr: "tcp@:1"}, time.Hour)
+	registry.Heartbeat(ts2.URL, registry.ServerItem{Addr: "tcp@:2"}, time.Hour)
+	d := NewGeeRegistryWatchDiscovery(ts1.URL+","+ts2.URL, "", 10*time.Second)
+	defer func() { _ = d.Close() }()
+	servers, _ := d.GetAll()
+	_assert(reflect.DeepEqual(servers, []string{"tcp@:1"}), "expect the servers of the first registry, got %v", servers)
+
+	ts1.CloseClientConnections() // kill the registry serving the watch
+	ts1.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if servers, _ = d.MultiServersDiscovery.GetAll(); reflect.DeepEqual(servers, []string{"tcp@:2"}) {
+			break
+		}
+		_assert(time.Now().Before(deadline), "expect the servers of the second registry, got %v", servers)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGeeRegistryWatchDiscovery_deregister(t *testing.T) {
+	ts := httptest.NewServer(registry.New(time.Minute))
+	defer ts.Close()
+	registry.Heartbeat(ts.URL, registry.ServerItem{Addr: "tcp@:1"}, time.Hour)
+	d := NewGeeRegistryWatchDiscovery(ts.URL, "", time.Second)
+	defer func() { _ = d.Close() }()
+	servers, _ := d.GetAll()
+	_assert(len(servers) == 1, "expect 1 server, got %v", servers)
+
+	_assert(registry.Deregister(ts.URL, "tcp@:1") == nil, "deregister failed")
+	deadline := time.Now().Add(time.Second)
+	for {
+		if servers, _ = d.MultiServersDiscovery.GetAll(); len(servers) == 0 {
+			break
+		}
+		_assert(time.Now().Before(deadline), "expect the server to be dropped, got %v", servers)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers")
+	_ = os.WriteFile(path, []byte("# fleet\ntcp@:1 3\n\ntcp@:2\n"), 0644)
+	d, err := NewFileDiscovery(path, 10*time.Millisecond)
+	_assert(err == nil, "load failed: %v", err)
+	defer func() { _ = d.Close() }()
+	servers, _ := d.GetAll()
+	_assert(reflect.DeepEqual(servers, []string{"tcp@:1", "tcp@:2"}), "expect 2 servers, got %v", servers)
+	_assert(d.weights["tcp@:1"] == 3 && d.weights["tcp@:2"] == 1, "unexpected weights %v", d.weights)
+
+	_ = os.WriteFile(path, []byte(`["tcp@:3", {"addr": "tcp@:4", "weight": 2}]`), 0644)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if servers, _ = d.GetAll(); reflect.DeepEqual(servers, []string{"tcp@:3", "tcp@:4"}) {
+			break
+		}
+		_assert(time.Now().Before(deadline), "expect the file to be reloaded, got %v", servers)
+		time.Sleep(10 * time.Millisecond)
+	}
+	addr, err := d.Get(WeightedRandomSelect)
+	_assert(err == nil && (addr == "tcp@:3" || addr == "tcp@:4"), "unexpected server %q %v", addr, err)
+
+	// an invalid file keeps the servers loaded last
+	_ = os.WriteFile(path, []byte("10.0.0.1:9999\n"), 0644)
+	_assert(d.Refresh() != nil, "expect an invalid address")
+	servers, _ = d.GetAll()
+	_assert(len(servers) == 2, "expect the servers to be kept, got %v", servers)
+}
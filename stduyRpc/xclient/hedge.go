@@ -0,0 +1,154 @@
+package xclient
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgePolicy configures hedged requests of a method, see XClient.Hedge.
+type HedgePolicy struct {
+	// Delay after which the request is also sent to a second server
+	// if the first one hasn't answered yet.
+	Delay time.Duration
+	// Percentile, if in (0, 100), derives the delay from the latency observed
+	// for the method instead, e.g. 95 hedges the requests slower than p95.
+	// Delay is used until enough calls have been observed.
+	Percentile float64
+}
+
+const (
+	hedgeSamples    = 128 // latencies kept per method
+	hedgeMinSamples = 16  // latencies needed before Percentile is used
+)
+
+type hedger struct {
+	policy HedgePolicy
+	mu     sync.Mutex // protect following
+	window []time.Duration
+	next   int // next slot of window to overwrite once it is full
+}
+
+// observe records the latency of a successful call.
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.window) < hedgeSamples {
+		h.window = append(h.window, d)
+		return
+	}
+	h.window[h.next] = d
+	h.next = (h.next + 1) % hedgeSamples
+}
+
+// delay returns how long to wait for the first server before hedging.
+func (h *hedger) delay() time.Duration {
+	p := h.policy.Percentile
+	if p <= 0 || p >= 100 {
+		return h.policy.Delay
+	}
+	h.mu.Lock()
+	if len(h.window) < hedgeMinSamples {
+		h.mu.Unlock()
+		return h.policy.Delay
+	}
+	sorted := make([]time.Duration, len(h.window))
+	copy(sorted, h.window)
+	h.mu.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(float64(len(sorted)-1)*p/100)]
+}
+
+// Hedge enables hedged requests for serviceMethod: Call sends the request to
+// a second server when the first one doesn't answer within the policy's
+// delay or fails before it, returns the first successful reply and cancels
+// the other call. Only use it for read-only, idempotent methods since both
+// servers may run the request.
+func (xc *XClient) Hedge(serviceMethod string, policy HedgePolicy) {
+	xc.hedges.Store(serviceMethod, &hedger{policy: policy})
+}
+
+func (xc *XClient) hedger(serviceMethod string) *hedger {
+	h, ok := xc.hedges.Load(serviceMethod)
+	if !ok {
+		return nil
+	}
+	return h.(*hedger)
+}
+
+func (xc *XClient) hedgedCall(ctx context.Context, h *hedger, serviceMethod string, args, reply interface{}) error {
+	first, err := xc.d.Get(xc.mode)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancel the loser
+	results := make(chan *Result, 2)
+	launch := func(rpcAddr string) {
+		go func() {
+			clonedReply := cloneReply(reply)
+			start := time.Now()
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			if err == nil {
+				h.observe(time.Since(start))
+			}
+			results <- &Result{Reply: clonedReply, Error: err}
+		}()
+	}
+	launch(first)
+	pending := 1
+	hedged := false
+	hedge := func() {
+		if hedged {
+			return
+		}
+		hedged = true
+		if second := xc.other(first); second != "" && ctx.Err() == nil {
+			launch(second)
+			pending++
+		}
+	}
+
+	timer := time.NewTimer(h.delay())
+	defer timer.Stop()
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			hedge()
+		case r := <-results:
+			pending--
+			if r.Error == nil {
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(r.Reply).Elem())
+				}
+				return nil
+			}
+			lastErr = r.Error
+			// the first server failed before the delay, don't wait for it to hedge
+			hedge()
+		}
+	}
+	return lastErr
+}
+
+// other returns a server other than rpcAddr, or "" if there is none.
+func (xc *XClient) other(rpcAddr string) string {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return ""
+	}
+	for i := 0; i < len(servers); i++ {
+		if s, err := xc.d.Get(xc.mode); err == nil && s != rpcAddr {
+			return s
+		}
+	}
+	for _, s := range servers {
+		if s != rpcAddr {
+			return s
+		}
+	}
+	return ""
+}
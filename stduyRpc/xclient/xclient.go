@@ -2,6 +2,7 @@ package xclient
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"reflect"
 	. "studyRpc/geerpc"
@@ -9,11 +10,13 @@ import (
 )
 
 type XClient struct {
-	d       Discovery          //服务发现实例
-	mode    SelectMode         //负载均衡模式
-	opt     *Option            //协议选项
-	mu      sync.Mutex         // protect following
-	clients map[string]*Client //Client 实例
+	d        Discovery          //服务发现实例
+	mode     SelectMode         //负载均衡模式
+	opt      *Option            //协议选项
+	mu       sync.Mutex         // protect following
+	clients  map[string]*Client //Client 实例
+	hedges   sync.Map           // serviceMethod -> *hedger
+	exporter Exporter           // see SetExporter
 }
 
 var _ io.Closer = (*XClient)(nil)
@@ -48,11 +51,25 @@ func (xc *XClient) dial(rpcAddr string) (*Client, error) {
 		if err != nil {
 			return nil, err
 		}
+		if xc.exporter != nil {
+			client.SetExporter(xc.exporter)
+		}
 		xc.clients[rpcAddr] = client
 	}
 	return client, nil
 }
 
+// SetExporter makes the clients of every server report their spans to e,
+// see Client.SetExporter.
+func (xc *XClient) SetExporter(e Exporter) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.exporter = e
+	for _, client := range xc.clients {
+		client.SetExporter(e)
+	}
+}
+
 func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
 	client, err := xc.dial(rpcAddr)
 	if err != nil {
@@ -65,6 +82,9 @@ func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod strin
 // and returns its error status.
 // xc will choose a proper server.
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	if h := xc.hedger(serviceMethod); h != nil {
+		return xc.hedgedCall(ctx, h, serviceMethod, args, reply)
+	}
 	rpcAddr, err := xc.d.Get(xc.mode)
 	if err != nil {
 		return err
@@ -72,6 +92,22 @@ func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply i
 	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
 }
 
+// Notify sends a one-way request to a server chosen by xc,
+// see Client.Notify.
+func (xc *XClient) Notify(ctx context.Context, serviceMethod string, args interface{}) error {
+	rpcAddr, err := xc.d.Get(xc.mode)
+	if err != nil {
+		return err
+	}
+	client, err := xc.dial(rpcAddr)
+	if err != nil {
+		return err
+	}
+	return client.Notify(ctx, serviceMethod, args)
+}
+
+// Broadcast invokes the named function for every server registered in discovery,
+// reply is set by the first successful call, and all calls are canceled on the first error.
 func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
 	servers, err := xc.d.GetAll()
 	if err != nil {
@@ -82,14 +118,12 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var e error
 	replyDone := reply == nil // if reply is nil, don't need to set value
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {
 			defer wg.Done()
-			var clonedReply interface{}
-			if reply != nil {
-				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
-			}
+			clonedReply := cloneReply(reply)
 			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
 			mu.Lock()
 			if err != nil && e == nil {
@@ -106,3 +140,98 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	wg.Wait()
 	return e
 }
+
+// Result is the outcome of a call to a single server.
+type Result struct {
+	Reply interface{} // a new value of the same type as the reply passed in, nil on error
+	Error error
+}
+
+// BroadcastAll invokes the named function for every server registered in discovery
+// and waits for all of them. It returns every server's result keyed by address.
+// reply is only used as a template for the type of the replies, it is left untouched.
+func (xc *XClient) BroadcastAll(ctx context.Context, serviceMethod string, args, reply interface{}) (map[string]*Result, error) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]*Result, len(servers))
+	for r := range xc.broadcast(ctx, servers, serviceMethod, args, reply) {
+		results[r.addr] = r.Result
+	}
+	return results, nil
+}
+
+// Quorum invokes the named function for every server registered in discovery
+// and returns as soon as n of them succeeded, canceling the remaining calls.
+// It returns the results received so far keyed by address, and an error if
+// n successes can no longer be reached. reply is only used as a template,
+// like in BroadcastAll.
+func (xc *XClient) Quorum(ctx context.Context, n int, serviceMethod string, args, reply interface{}) (map[string]*Result, error) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 || n > len(servers) {
+		return nil, fmt.Errorf("rpc xclient: quorum of %d is unreachable with %d servers", n, len(servers))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(map[string]*Result, len(servers))
+	succeeded, failed := 0, 0
+	var lastErr error
+	for r := range xc.broadcast(ctx, servers, serviceMethod, args, reply) {
+		results[r.addr] = r.Result
+		if r.Error != nil {
+			failed++
+			lastErr = r.Error
+		} else {
+			succeeded++
+		}
+		if succeeded >= n {
+			return results, nil
+		}
+		if len(servers)-failed < n {
+			return results, fmt.Errorf("rpc xclient: quorum of %d not reached, %d of %d servers failed: %v", n, failed, len(servers), lastErr)
+		}
+	}
+	return results, ctx.Err()
+}
+
+type addrResult struct {
+	addr string
+	*Result
+}
+
+// broadcast calls every server concurrently and delivers the results on
+// the returned channel, which is closed once all calls are done. The
+// channel is buffered so callers may stop receiving early.
+func (xc *XClient) broadcast(ctx context.Context, servers []string, serviceMethod string, args, reply interface{}) <-chan addrResult {
+	ch := make(chan addrResult, len(servers))
+	var wg sync.WaitGroup
+	for _, rpcAddr := range servers {
+		wg.Add(1)
+		go func(rpcAddr string) {
+			defer wg.Done()
+			clonedReply := cloneReply(reply)
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			if err != nil {
+				clonedReply = nil
+			}
+			ch <- addrResult{addr: rpcAddr, Result: &Result{Reply: clonedReply, Error: err}}
+		}(rpcAddr)
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+// cloneReply returns a pointer to a new value of the type reply points to.
+func cloneReply(reply interface{}) interface{} {
+	if reply == nil {
+		return nil
+	}
+	return reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+}
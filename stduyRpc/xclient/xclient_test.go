@@ -0,0 +1,106 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"studyRpc/geerpc"
+	"testing"
+	"time"
+)
+
+type Args struct{ Num1, Num2 int }
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+	}
+}
+
+// startServer serves Foo.Sum on an in-process listener, sleeping delay
+// before each reply and failing if fail is set.
+func startServer(t *testing.T, name string, delay time.Duration, fail bool) string {
+	server := geerpc.NewServer()
+	_ = server.RegisterFunc("Foo.Sum", func(args Args, reply *int) error {
+		time.Sleep(delay)
+		if fail {
+			return errors.New("sum failed")
+		}
+		*reply = args.Num1 + args.Num2
+		return nil
+	})
+	l, err := geerpc.ListenInproc(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	return "inproc@" + name
+}
+
+func TestXClient_BroadcastAll(t *testing.T) {
+	ok1 := startServer(t, "broadcast-ok1", 0, false)
+	ok2 := startServer(t, "broadcast-ok2", 0, false)
+	bad := startServer(t, "broadcast-bad", 0, true)
+	xc := NewXClient(NewMultiServerDiscovery([]string{ok1, ok2, bad}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	results, err := xc.BroadcastAll(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, new(int))
+	_assert(err == nil && len(results) == 3, "expect 3 results, got %d, %v", len(results), err)
+	_assert(results[ok1].Error == nil && *results[ok1].Reply.(*int) == 3, "wrong result of %s", ok1)
+	_assert(results[ok2].Error == nil && *results[ok2].Reply.(*int) == 3, "wrong result of %s", ok2)
+	_assert(results[bad].Error != nil && results[bad].Reply == nil, "expect an error from %s", bad)
+}
+
+func TestXClient_Quorum(t *testing.T) {
+	fast1 := startServer(t, "quorum-fast1", 0, false)
+	fast2 := startServer(t, "quorum-fast2", 0, false)
+	slow := startServer(t, "quorum-slow", time.Second, false)
+	bad := startServer(t, "quorum-bad", 0, true)
+	xc := NewXClient(NewMultiServerDiscovery([]string{fast1, fast2, slow, bad}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	start := time.Now()
+	results, err := xc.Quorum(context.Background(), 2, "Foo.Sum", Args{Num1: 1, Num2: 2}, new(int))
+	_assert(err == nil && time.Since(start) < time.Second, "expect quorum from the fast servers, got %v", err)
+	_assert(results[fast1] != nil && results[fast2] != nil, "expect results of the fast servers")
+
+	_, err = xc.Quorum(context.Background(), 4, "Foo.Sum", Args{}, new(int))
+	_assert(err != nil, "expect an error when the quorum can't be reached")
+}
+
+func TestXClient_Hedge(t *testing.T) {
+	slow := startServer(t, "hedge-slow", time.Second, false)
+	fast := startServer(t, "hedge-fast", 0, false)
+	xc := NewXClient(NewMultiServerDiscovery([]string{slow, fast}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.Hedge("Foo.Sum", HedgePolicy{Delay: 50 * time.Millisecond})
+
+	for i := 0; i < 2; i++ { // round robin, so one of them starts on the slow server
+		var reply int
+		start := time.Now()
+		err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "failed to call Foo.Sum: %v", err)
+		_assert(time.Since(start) < time.Second/2, "expect the hedged request to win, took %s", time.Since(start))
+	}
+
+	// a failing first server is hedged right away
+	bad := startServer(t, "hedge-bad", 0, true)
+	xc2 := NewXClient(NewMultiServerDiscovery([]string{bad, fast}), RoundRobinSelect, nil)
+	defer func() { _ = xc2.Close() }()
+	xc2.Hedge("Foo.Sum", HedgePolicy{Delay: time.Second})
+	for i := 0; i < 2; i++ {
+		var reply int
+		start := time.Now()
+		err := xc2.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "failed to call Foo.Sum: %v", err)
+		_assert(time.Since(start) < time.Second/2, "expect the failure to be hedged at once, took %s", time.Since(start))
+	}
+
+	h := &hedger{policy: HedgePolicy{Delay: time.Second, Percentile: 50}}
+	_assert(h.delay() == time.Second, "expect the fixed delay without samples")
+	for i := 1; i <= hedgeMinSamples; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	_assert(h.delay() < time.Second, "expect the delay from observed latency, got %s", h.delay())
+}